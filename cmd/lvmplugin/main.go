@@ -2,14 +2,23 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
+	"time"
 
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
 	"github.com/metal-stack/csi-driver-lvm/pkg/server"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 var (
@@ -22,6 +31,11 @@ var (
 	showVersion       = flag.Bool("version", false, "Show version.")
 	devicesPattern    = flag.String("devices", "", "comma-separated grok patterns of the physical volumes to use.")
 	vgName            = flag.String("vgname", "csi-lvm", "name of volume group")
+	deviceClasses     = flag.String("device-classes", "", `json list of additional device classes with their own volume group, e.g. [{"name":"hdd","vgName":"csi-lvm-hdd","devicePattern":"/dev/sd[b-d]"}]`)
+	thinPoolSize      = flag.Int("thinpool-size", 50, "size of the thin pool in percent of the free space of the volume group, the thin pool is created with the first thin volume")
+	thinOvercommit    = flag.Float64("thin-overcommit-ratio", 10, "ratio by which the sum of all thin volume sizes may exceed the size of the thin pool")
+	commandTimeout    = flag.Duration("command-timeout", 2*time.Minute, "maximum runtime of a single lvm, mkfs or mount command, 0 disables the timeout")
+	peerPort          = flag.Int("peer-port", 0, "port on which volume expansions are forwarded between the node plugins, the token is read from the PEER_TOKEN environment variable, 0 disables forwarding")
 	logLevel          = flag.String("log-level", "info", "log-level of the application")
 
 	// Set by the build process
@@ -65,7 +79,34 @@ func handle() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	driver, err := server.NewDriver(log, *driverName, *nodeID, *endpoint, *hostWritePath, *ephemeral, *maxVolumesPerNode, version, *devicesPattern, *vgName)
+	classes := []server.DeviceClass{{
+		Name:           server.DefaultDeviceClass,
+		VGName:         *vgName,
+		DevicesPattern: *devicesPattern,
+	}}
+	if *deviceClasses != "" {
+		var additional []server.DeviceClass
+		err := json.Unmarshal([]byte(*deviceClasses), &additional)
+		if err != nil {
+			log.Error("unable to parse device classes", "error", err)
+			os.Exit(1)
+		}
+		classes = append(classes, additional...)
+	}
+
+	peers := server.PeerConfig{
+		Port:  *peerPort,
+		Token: os.Getenv("PEER_TOKEN"),
+	}
+	if *peerPort > 0 {
+		peers.Address, err = nodeAddress(*peerPort)
+		if err != nil {
+			log.Error("unable to create kubernetes client for the peer addresses", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	driver, err := server.NewDriver(log, *driverName, *nodeID, *endpoint, *hostWritePath, *ephemeral, *maxVolumesPerNode, version, classes, *thinPoolSize, *thinOvercommit, *commandTimeout, lvm.OSExecutor{}, peers)
 	if err != nil {
 		log.Error("failed to initialize driver", "error", err)
 		os.Exit(1)
@@ -73,3 +114,28 @@ func handle() {
 
 	driver.Run(ctx)
 }
+
+// nodeAddress returns a lookup of the node plugin addresses, the node plugins listen on the given port of the internal ip of their node
+func nodeAddress(port int) (func(ctx context.Context, node string) (string, error), error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, node string) (string, error) {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, address := range n.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				return net.JoinHostPort(address.Address, strconv.Itoa(port)), nil
+			}
+		}
+		return "", fmt.Errorf("node %s has no internal ip", node)
+	}, nil
+}
@@ -0,0 +1,73 @@
+package lvm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// ErrNotFound is returned if a volume group or logical volume does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned if a logical volume with the same name was created in the meantime
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrInsufficientSpace is returned if the volume group or thin pool has not enough free extents left
+	ErrInsufficientSpace = errors.New("insufficient free space")
+	// ErrInvalidArgument is returned for parameters lvm can not handle, like an unknown lvm type
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrBusy is returned if a volume is in use or lvm is locked by another command, the operation can be retried later
+	ErrBusy = errors.New("busy")
+	// ErrMountConflict is returned if another device or the same device with different options is mounted at the target
+	ErrMountConflict = errors.New("mount conflict")
+	// ErrDeviceInUse is returned if the content of a raw block volume can not be changed while it is opened by its user
+	ErrDeviceInUse = errors.New("device in use")
+	// ErrCorrupted is returned if a filesystem check found errors which were not repaired
+	ErrCorrupted = errors.New("filesystem corrupted")
+)
+
+// classifications map the messages of the lvm and mount commands to the errors above
+var classifications = []struct {
+	pattern *regexp.Regexp
+	kind    error
+}{
+	{regexp.MustCompile(`(?i)insufficient (free space|free extents|suitable allocatable extents)|not enough free space|no space left`), ErrInsufficientSpace},
+	{regexp.MustCompile(`(?i)(volume group|logical volume|thin pool)[^\n]*not found|failed to find logical volume|no such (file or directory|device)`), ErrNotFound},
+	{regexp.MustCompile(`(?i)(logical volume|volume group)[^\n]*already exists`), ErrAlreadyExists},
+	// "in use" and "invalid argument" alone are also part of unrelated kernel messages, only the lvm messages are matched
+	{regexp.MustCompile(`(?i)device or resource busy|target is busy|logical volume [^\n]*in use|can't remove open logical volume|can't get lock for`), ErrBusy},
+	{regexp.MustCompile(`(?i)invalid argument for (-|--)[a-z]|names (starting|including) [^\n]* are reserved|segment type [^\n]* not supported|unknown (option|segment type)`), ErrInvalidArgument},
+}
+
+// classifiedError keeps the message of err while it is also matched by errors.Is against kind
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// newError returns an error of the given kind with the formatted message
+func newError(kind error, format string, a ...any) error {
+	return &classifiedError{err: fmt.Errorf(format, a...), kind: kind}
+}
+
+// classify attaches the kind of error which is indicated by the output of a failed command
+func classify(output string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, c := range classifications {
+		if c.pattern.MatchString(output) {
+			return &classifiedError{err: err, kind: c.kind}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,55 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	exitErr := errors.New("exit status 5")
+
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{name: "insufficient free space", output: `  Volume group "csi-lvm" has insufficient free space (10 extents): 25 required.`, want: ErrInsufficientSpace},
+		{name: "insufficient allocatable extents", output: `  Insufficient suitable allocatable extents for logical volume pvc-1: 50 more required`, want: ErrInsufficientSpace},
+		{name: "no space left", output: `dd: error writing '/dev/csi-lvm/pvc-1': No space left on device`, want: ErrInsufficientSpace},
+		{name: "volume group not found", output: `  Volume group "csi-lvm" not found`, want: ErrNotFound},
+		{name: "logical volume not found", output: `  Failed to find logical volume "csi-lvm/pvc-1"`, want: ErrNotFound},
+		{name: "thin pool not found", output: `  Thin pool csi-lvm/csi-lvm-thinpool not found`, want: ErrNotFound},
+		{name: "missing device", output: `mount: /mnt: special device /dev/csi-lvm/pvc-1 does not exist: No such file or directory`, want: ErrNotFound},
+		{name: "logical volume already exists", output: `  Logical Volume "pvc-1" already exists in volume group "csi-lvm"`, want: ErrAlreadyExists},
+		{name: "logical volume in use", output: `  Logical volume csi-lvm/pvc-1 in use.`, want: ErrBusy},
+		{name: "open logical volume", output: `  Can't remove open logical volume "pvc-1"`, want: ErrBusy},
+		{name: "target busy", output: `umount: /mnt: target is busy.`, want: ErrBusy},
+		{name: "lock", output: `  Can't get lock for csi-lvm`, want: ErrBusy},
+		{name: "invalid option argument", output: `  Invalid argument for --stripes: x`, want: ErrInvalidArgument},
+		{name: "reserved name", output: `  Names starting "snapshot" are reserved. Please choose a different LV name.`, want: ErrInvalidArgument},
+		{name: "unknown segment type", output: `  Unknown segment type raid7`, want: ErrInvalidArgument},
+		{name: "kernel invalid argument", output: `mount: /mnt: wrong fs type, bad option, bad superblock on /dev/csi-lvm/pvc-1: Invalid argument`},
+		{name: "unknown message", output: `  Internal error: unexpected failure`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.output, exitErr)
+			if !errors.Is(err, exitErr) {
+				t.Errorf("error %v does not wrap the error of the command", err)
+			}
+			if err.Error() != exitErr.Error() {
+				t.Errorf("got message %q, want the message of the command %q", err.Error(), exitErr.Error())
+			}
+
+			for _, kind := range []error{ErrNotFound, ErrAlreadyExists, ErrInsufficientSpace, ErrInvalidArgument, ErrBusy} {
+				if got := errors.Is(err, kind); got != (kind == tt.want) {
+					t.Errorf("errors.Is(%v) = %t, want kind %v", kind, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if err := classify("  Volume group \"csi-lvm\" not found", nil); err != nil {
+		t.Errorf("got error %v for a successful command", err)
+	}
+}
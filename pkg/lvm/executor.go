@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Executor runs the lvm, filesystem and mount commands on behalf of the Client
+type Executor interface {
+	// Execute runs the command and returns what it wrote to stdout and stderr,
+	// the command must be stopped as soon as ctx is done
+	Execute(ctx context.Context, name string, args ...string) (stdout []byte, stderr []byte, err error)
+	// ReadFile returns the content of a file of the host like /proc/self/mountinfo
+	ReadFile(name string) ([]byte, error)
+	// OpenExclusive opens the block device with O_EXCL and closes it again, it fails with EBUSY
+	// while the device is mounted or held exclusively by someone else
+	OpenExclusive(device string) error
+}
+
+// OSExecutor runs the commands on the host
+type OSExecutor struct{}
+
+// Execute runs the command with os/exec, it is killed when ctx is done
+func (OSExecutor) Execute(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...) //nolint:gosec
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// do not wait forever for child processes which inherited stdout or stderr
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// ReadFile reads the file with os.ReadFile
+func (OSExecutor) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// OpenExclusive opens the device with O_EXCL, which the kernel only grants to block devices without other exclusive holders
+func (OSExecutor) OpenExclusive(device string) error {
+	f, err := os.OpenFile(device, os.O_RDONLY|os.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Client manages volume groups and logical volumes through an Executor
+type Client struct {
+	log  *slog.Logger
+	exec Executor
+	// timeout bounds the runtime of every command, 0 means that only the context of the caller applies
+	timeout time.Duration
+	// thinPools holds a mutex for every thin pool, see lockThinPool
+	thinPools sync.Map
+}
+
+// New returns a Client which runs all commands with the given executor, each command is stopped after timeout
+func New(log *slog.Logger, executor Executor, timeout time.Duration) *Client {
+	return &Client{
+		log:     log,
+		exec:    executor,
+		timeout: timeout,
+	}
+}
+
+// run executes the command and returns stdout and stderr combined
+func (c *Client) run(ctx context.Context, name string, args ...string) (string, error) {
+	stdout, stderr, err := c.execute(ctx, name, args...)
+	return string(stdout) + string(stderr), err
+}
+
+// execute runs the command within the command timeout of the client
+func (c *Client) execute(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	return c.executeWithTimeout(ctx, c.timeout, name, args...)
+}
+
+// executeWithTimeout runs the command, it is stopped when ctx is done or after timeout if timeout is greater than 0.
+// The returned error wraps the error of the context if the command was stopped, otherwise it is classified by the output of the command.
+func (c *Client) executeWithTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, []byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	stdout, stderr, err := c.exec.Execute(ctx, name, args...)
+	if err != nil && ctx.Err() != nil {
+		c.log.Error("command was killed", "command", name, "args", strings.Join(args, " "), "duration", time.Since(start).String(), "stdout", string(stdout), "stderr", string(stderr), "error", ctx.Err())
+		return stdout, stderr, fmt.Errorf("%s did not finish in time: %w", name, context.Cause(ctx))
+	}
+
+	return stdout, stderr, classify(string(stdout)+string(stderr), err)
+}
@@ -0,0 +1,1450 @@
+// Package fake provides an in-memory lvm.Executor which simulates volume groups, logical volumes,
+// their free extents, filesystems and mounts, so the driver can be exercised without block devices.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+)
+
+// DefaultExtentSize is the physical extent size of all simulated volume groups, the same as the lvm default
+const DefaultExtentSize = 4 * 1024 * 1024
+
+// lvTimeLayout is the format lvm uses for the lv_time field
+const lvTimeLayout = "2006-01-02 15:04:05 -0700"
+
+// valueFlags are the command line flags which are followed by a value
+var valueFlags = map[string]bool{
+	"-n": true, "--name": true, "-L": true, "--size": true, "-l": true, "--extents": true, "-V": true, "--virtualsize": true,
+	"-W": true, "--type": true, "-i": true, "--stripes": true, "-I": true, "--stripesize": true, "-m": true, "--mirrors": true,
+	"--raidintegrity": true, "--addtag": true, "--deltag": true, "--thinpool": true, "--setactivationskip": true, "--permission": true,
+	"-S": true, "--select": true, "-o": true, "--options": true, "--output": true, "--units": true, "--reportformat": true, "-t": true,
+	"-E": true,
+}
+
+// booleanFlags overrides valueFlags for commands where the same flag has no value
+var booleanFlags = map[string]map[string]bool{
+	// lvextend -n is --nofsck
+	"lvextend": {"-n": true},
+	// fsck -n is a dry run
+	"e2fsck":     {"-n": true},
+	"xfs_repair": {"-n": true},
+}
+
+// ExitError is returned by the fake for commands which failed, like exec.ExitError it carries the exit code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit code of the failed command
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+type physicalVolume struct {
+	name      string
+	uuid      string
+	extents   int64
+	allocated int64
+}
+
+type logicalVolume struct {
+	name    string
+	uuid    string
+	segType string
+	attr    string
+	// size is the size in bytes which is visible to the user
+	size int64
+	// legs is the number of physical volumes the extents of each segment are spread over, stripes or raid images
+	legs      int
+	stripes   int
+	origin    string
+	pool      string
+	integrity bool
+	// syncPercent is the synchronized percentage of raid volumes
+	syncPercent float64
+	// dataPercent and metadataPercent are the allocated percentages of thin pools
+	dataPercent     float64
+	metadataPercent float64
+	tags            []string
+	created         time.Time
+	allocation      map[string]int64
+}
+
+type mount struct {
+	target string
+	// device is the block device whose filesystem is mounted, or whose device node is bind mounted if node is true
+	device  string
+	node    bool
+	options []string
+}
+
+type volumeGroup struct {
+	name string
+	uuid string
+	tags []string
+	pvs  []*physicalVolume
+	lvs  []*logicalVolume
+}
+
+// Executor simulates the lvm, filesystem and mount commands used by the lvm package
+type Executor struct {
+	mu sync.Mutex
+
+	uuids       int
+	devices     map[string]int64
+	vgs         map[string]*volumeGroup
+	filesystems map[string]string
+	// corruptions are the devices whose filesystem has errors, true if fsck can repair them
+	corruptions map[string]bool
+	// dirty are the devices whose filesystem journal was not replayed after an unclean shutdown
+	dirty map[string]bool
+	// numbers are the minor device numbers by device path
+	numbers map[string]int
+	// mounts are in the order they were mounted
+	mounts []mount
+	// opened are the devices which are opened by an application without mounting them
+	opened   map[string]bool
+	failures map[string]string
+	hangs    map[string]bool
+	delays   map[string]time.Duration
+	commands [][]string
+}
+
+var _ lvm.Executor = &Executor{}
+
+// New returns an Executor without any devices
+func New() *Executor {
+	return &Executor{
+		devices:     map[string]int64{},
+		vgs:         map[string]*volumeGroup{},
+		filesystems: map[string]string{},
+		corruptions: map[string]bool{},
+		dirty:       map[string]bool{},
+		numbers:     map[string]int{},
+		opened:      map[string]bool{},
+		failures:    map[string]string{},
+		hangs:       map[string]bool{},
+		delays:      map[string]time.Duration{},
+	}
+}
+
+// AddDevice adds an unused block device with the given size which can be used by vgcreate
+func (e *Executor) AddDevice(device string, size int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.devices[device] = size
+}
+
+// AddVG creates a volume group with one physical volume of the given size for every entry of sizes
+func (e *Executor) AddVG(name string, sizes ...int64) error {
+	args := []string{name}
+	for i, size := range sizes {
+		device := fmt.Sprintf("/dev/fake-%s-%d", name, i)
+		e.AddDevice(device, size)
+		args = append(args, device)
+	}
+
+	_, stderr, err := e.Execute(context.Background(), "vgcreate", args...)
+	if err != nil {
+		return fmt.Errorf("%w (%s)", err, string(stderr))
+	}
+
+	return nil
+}
+
+// Fail lets every following invocation of command fail with the given message on stderr until Recover is called
+func (e *Executor) Fail(command string, stderr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures[command] = stderr
+}
+
+// Hang lets every following invocation of command block until its context is done, like a command waiting
+// for a stuck device, until Recover is called
+func (e *Executor) Hang(command string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.hangs[command] = true
+}
+
+// Delay lets every following invocation of command take at least d before it runs, like a slow device,
+// so concurrent callers overlap, until Recover is called
+func (e *Executor) Delay(command string, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.delays[command] = d
+}
+
+// Recover lets command succeed again after Fail, Hang or Delay
+func (e *Executor) Recover(command string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.failures, command)
+	delete(e.hangs, command)
+	delete(e.delays, command)
+}
+
+// InvalidateSnapshot marks the snapshot as invalid, as lvm does when a snapshot runs out of space
+func (e *Executor) InvalidateSnapshot(vg string, name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	v, ok := e.vgs[vg]
+	if !ok {
+		return fmt.Errorf("volume group %s does not exist", vg)
+	}
+	lv := v.lv(name)
+	if lv == nil || lv.origin == "" {
+		return fmt.Errorf("snapshot %s does not exist", name)
+	}
+
+	lv.attr = "S" + lv.attr[1:]
+
+	return nil
+}
+
+// SetThinPoolUsage sets the allocated percentages of the data and metadata of the thin pool, pools start empty
+func (e *Executor) SetThinPoolUsage(vg string, pool string, dataPercent float64, metadataPercent float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	v, ok := e.vgs[vg]
+	if !ok {
+		return fmt.Errorf("volume group %s does not exist", vg)
+	}
+	lv := v.lv(pool)
+	if lv == nil || lv.segType != "thin-pool" {
+		return fmt.Errorf("thin pool %s does not exist", pool)
+	}
+
+	lv.dataPercent = dataPercent
+	lv.metadataPercent = metadataPercent
+
+	return nil
+}
+
+// SetSyncPercent sets how far the images of the raid volume are synchronized, raid volumes which were
+// created with --nosync are in sync right away, all others start at 0.
+func (e *Executor) SetSyncPercent(vg string, name string, percent float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	v, ok := e.vgs[vg]
+	if !ok {
+		return fmt.Errorf("volume group %s does not exist", vg)
+	}
+	lv := v.lv(name)
+	if lv == nil || !strings.HasPrefix(lv.segType, "raid") {
+		return fmt.Errorf("raid volume %s does not exist", name)
+	}
+
+	lv.syncPercent = percent
+
+	return nil
+}
+
+// Corrupt adds errors to the filesystem of the device, which can be repaired by fsck if repairable is true
+func (e *Executor) Corrupt(device string, repairable bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.corruptions[device] = repairable
+}
+
+// DirtyJournal leaves the journal of the filesystem of the device unreplayed, like an unclean shutdown.
+// A mount replays it, checks without repairs report errors until then.
+func (e *Executor) DirtyJournal(device string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.dirty[device] = true
+}
+
+// Commands returns all commands which have been executed so far
+func (e *Executor) Commands() [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return slices.Clone(e.commands)
+}
+
+// Open simulates an application like a virtual machine which opens the device without mounting it, until Close is called
+func (e *Executor) Open(device string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.opened[device] = true
+}
+
+// Close ends Open
+func (e *Executor) Close(device string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.opened, device)
+}
+
+// Mounts returns the mounted devices by target path, for bind mounts of a directory it is the device of the directory
+func (e *Executor) Mounts() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	mounts := map[string]string{}
+	for _, m := range e.mounts {
+		mounts[m.target] = m.device
+	}
+	return mounts
+}
+
+// Execute simulates the given command, like a killed process it fails if ctx is done
+func (e *Executor) Execute(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	e.mu.Lock()
+	e.commands = append(e.commands, append([]string{name}, args...))
+	hang := e.hangs[name]
+	delay := e.delays[name]
+	e.mu.Unlock()
+
+	if hang {
+		<-ctx.Done()
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("signal: killed")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if stderr, ok := e.failures[name]; ok {
+		return nil, []byte(stderr), &ExitError{Code: 5}
+	}
+
+	flags, positional := parseArgs(name, args)
+
+	var (
+		stdout string
+		err    error
+	)
+	switch {
+	case name == "pvs":
+		stdout, err = e.pvs(flags)
+	case name == "vgs":
+		stdout, err = e.vgsReport()
+	case name == "lvs":
+		stdout, err = e.lvsReport(positional)
+	case name == "vgscan", name == "vgchange":
+	case name == "vgcreate":
+		err = e.vgcreate(flags, positional)
+	case name == "lvcreate":
+		err = e.lvcreate(flags, positional)
+	case name == "lvextend":
+		err = e.lvextend(flags, positional)
+	case name == "lvchange":
+		err = e.lvchange(flags, positional)
+	case name == "lvremove":
+		err = e.lvremove(positional)
+	case name == "lsblk":
+		stdout, err = e.lsblk(positional)
+	case strings.HasPrefix(name, "mkfs."):
+		err = e.mkfs(strings.TrimPrefix(name, "mkfs."), positional)
+	case name == "mount":
+		err = e.mount(flags, positional)
+	case name == "umount":
+		err = e.umount(positional)
+	case name == "dd":
+		err = e.dd(positional)
+	case name == "btrfs":
+		err = e.btrfs(positional)
+	case name == "e2fsck", name == "xfs_repair", name == "fsck.f2fs":
+		err = e.fsck(name, flags, positional)
+	case name == "resize2fs", name == "xfs_growfs":
+		err = e.grow(name, positional)
+	case name == "resize.f2fs":
+		err = e.resizeF2FS(positional)
+	default:
+		return nil, fmt.Appendf(nil, "%s: command not found", name), &ExitError{Code: 127}
+	}
+
+	if err != nil {
+		var code = 5
+		if ce, ok := err.(*commandError); ok {
+			code = ce.code
+		}
+		return []byte(stdout), []byte(err.Error()), &ExitError{Code: code}
+	}
+
+	return []byte(stdout), nil, nil
+}
+
+// commandError is the message a command prints to stderr and its exit code
+type commandError struct {
+	code    int
+	message string
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+func failf(code int, format string, a ...any) error {
+	return &commandError{code: code, message: fmt.Sprintf(format, a...)}
+}
+
+// parseArgs splits the arguments into flags and positional arguments, flags which occur more than once keep all values
+func parseArgs(command string, args []string) (map[string][]string, []string) {
+	var (
+		flags      = map[string][]string{}
+		positional []string
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case valueFlags[arg] && !booleanFlags[command][arg] && i+1 < len(args):
+			flags[arg] = append(flags[arg], args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			flags[arg] = append(flags[arg], "")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	return flags, positional
+}
+
+func flag(flags map[string][]string, names ...string) (string, bool) {
+	for _, name := range names {
+		if values, ok := flags[name]; ok {
+			return values[len(values)-1], true
+		}
+	}
+	return "", false
+}
+
+// parseSize parses an lvm size argument, the default unit is megabytes
+func parseSize(value string) (int64, error) {
+	units := map[byte]int64{'b': 1, 'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30, 't': 1 << 40}
+
+	unit := int64(1 << 20)
+	if len(value) > 0 {
+		if u, ok := units[strings.ToLower(value[len(value)-1:])[0]]; ok {
+			unit = u
+			value = value[:len(value)-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, failf(3, "  Invalid argument for --size: %s", value)
+	}
+
+	return size * unit, nil
+}
+
+func (e *Executor) uuid() string {
+	e.uuids++
+	return fmt.Sprintf("fake-%06d", e.uuids)
+}
+
+func (v *volumeGroup) lv(name string) *logicalVolume {
+	for _, lv := range v.lvs {
+		if lv.name == name {
+			return lv
+		}
+	}
+	return nil
+}
+
+func (v *volumeGroup) freeExtents() int64 {
+	var free int64
+	for _, pv := range v.pvs {
+		free += pv.extents - pv.allocated
+	}
+	return free
+}
+
+// allocate reserves count extents on each of legs different physical volumes, a single leg may span several physical volumes
+func (v *volumeGroup) allocate(legs int, count int64) (map[string]int64, error) {
+	insufficient := failf(5, "  Insufficient free space: %d extents needed, but only %d available", int64(legs)*count, v.freeExtents())
+
+	pvs := slices.Clone(v.pvs)
+	slices.SortStableFunc(pvs, func(a, b *physicalVolume) int {
+		return int((b.extents - b.allocated) - (a.extents - a.allocated))
+	})
+
+	allocation := map[string]int64{}
+	if legs == 1 {
+		if v.freeExtents() < count {
+			return nil, insufficient
+		}
+		remaining := count
+		for _, pv := range pvs {
+			n := min(remaining, pv.extents-pv.allocated)
+			if n > 0 {
+				allocation[pv.name] = n
+				remaining -= n
+			}
+		}
+	} else {
+		if len(pvs) < legs || pvs[legs-1].extents-pvs[legs-1].allocated < count {
+			return nil, insufficient
+		}
+		for _, pv := range pvs[:legs] {
+			allocation[pv.name] = count
+		}
+	}
+
+	for _, pv := range v.pvs {
+		pv.allocated += allocation[pv.name]
+	}
+
+	return allocation, nil
+}
+
+func (v *volumeGroup) release(lv *logicalVolume) {
+	for _, pv := range v.pvs {
+		pv.allocated -= lv.allocation[pv.name]
+	}
+	lv.allocation = nil
+}
+
+func (e *Executor) vgcreate(flags map[string][]string, positional []string) error {
+	if len(positional) < 2 {
+		return failf(3, "  Please enter a volume group name and physical volumes")
+	}
+
+	name := positional[0]
+	if _, ok := e.vgs[name]; ok {
+		return failf(5, "  A volume group called %s already exists.", name)
+	}
+
+	v := &volumeGroup{name: name, uuid: e.uuid(), tags: flags["--addtag"]}
+	for _, device := range positional[1:] {
+		size, ok := e.devices[device]
+		if !ok {
+			return failf(5, "  No device found for %s.", device)
+		}
+		for _, other := range e.vgs {
+			if slices.ContainsFunc(other.pvs, func(pv *physicalVolume) bool { return pv.name == device }) {
+				return failf(5, "  Physical volume '%s' is already in volume group '%s'", device, other.name)
+			}
+		}
+		v.pvs = append(v.pvs, &physicalVolume{name: device, uuid: e.uuid(), extents: size / DefaultExtentSize})
+	}
+
+	e.vgs[name] = v
+
+	return nil
+}
+
+// target returns the volume group and logical volume of an argument in the form vg/lv
+func (e *Executor) target(arg string) (*volumeGroup, string, error) {
+	vgName, lvName, _ := strings.Cut(arg, "/")
+	v, ok := e.vgs[vgName]
+	if !ok {
+		return nil, "", failf(5, "  Volume group %q not found", vgName)
+	}
+	return v, lvName, nil
+}
+
+func (e *Executor) lvcreate(flags map[string][]string, positional []string) error {
+	if len(positional) != 1 {
+		return failf(3, "  Please specify a volume group or origin")
+	}
+	v, originName, err := e.target(positional[0])
+	if err != nil {
+		return err
+	}
+
+	name, ok := flag(flags, "-n", "--name")
+	if !ok {
+		return failf(3, "  Please specify a logical volume name")
+	}
+	for _, reserved := range []string{"snapshot", "pvmove"} {
+		if strings.HasPrefix(name, reserved) {
+			return failf(3, "  Names starting \"%s\" are reserved. Please choose a different LV name.", reserved)
+		}
+	}
+	if v.lv(name) != nil {
+		return failf(5, "  Logical Volume %q already exists in volume group %q", name, v.name)
+	}
+
+	lv := &logicalVolume{
+		name:    name,
+		uuid:    e.uuid(),
+		segType: "linear",
+		attr:    "-wi-a-----",
+		legs:    1,
+		stripes: 1,
+		tags:    flags["--addtag"],
+		created: time.Now(),
+	}
+
+	_, snapshot := flags["--snapshot"]
+	if _, s := flags["-s"]; s {
+		snapshot = true
+	}
+	lvmType, _ := flag(flags, "--type")
+	thinPool, _ := flag(flags, "--thinpool")
+
+	switch {
+	case snapshot:
+		origin := v.lv(originName)
+		if origin == nil {
+			return failf(5, "  Failed to find logical volume \"%s/%s\"", v.name, originName)
+		}
+		lv.origin = origin.name
+		if origin.segType == "thin" {
+			if _, ok := flag(flags, "-L", "--size"); !ok {
+				lv.segType = "thin"
+				lv.attr = "Vwi---tz-k"
+				lv.pool = origin.pool
+				lv.size = origin.size
+				break
+			}
+		}
+		lv.attr = "swi-a-s---"
+		if err := e.allocateSized(v, lv, flags); err != nil {
+			return err
+		}
+	case lvmType == "thin-pool":
+		extents, _ := flag(flags, "-l", "--extents")
+		percent, ok := strings.CutSuffix(extents, "%FREE")
+		if !ok {
+			return failf(3, "  Only %%FREE is supported for thin pools, got %s", extents)
+		}
+		p, err := strconv.ParseInt(percent, 10, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return failf(3, "  Invalid argument for --extents: %s", extents)
+		}
+		count := v.freeExtents() * p / 100
+		if count == 0 {
+			return failf(5, "  Insufficient free space: 1 extents needed, but only 0 available")
+		}
+		allocation, err := v.allocate(1, count)
+		if err != nil {
+			return err
+		}
+		lv.segType = "thin-pool"
+		lv.attr = "twi-a-tz--"
+		lv.size = count * DefaultExtentSize
+		lv.allocation = allocation
+	case thinPool != "":
+		pool := v.lv(thinPool)
+		if pool == nil || pool.segType != "thin-pool" {
+			return failf(5, "  Thin pool %s/%s not found", v.name, thinPool)
+		}
+		virtual, _ := flag(flags, "-V", "--virtualsize")
+		size, err := parseSize(virtual)
+		if err != nil {
+			return err
+		}
+		lv.segType = "thin"
+		lv.attr = "Vwi-a-tz--"
+		lv.pool = thinPool
+		lv.size = roundUp(size, DefaultExtentSize)
+	default:
+		switch lvmType {
+		case "", "linear":
+		case "striped":
+			stripes, _ := flag(flags, "-i", "--stripes")
+			n, err := strconv.Atoi(stripes)
+			if err != nil || n < 1 {
+				return failf(3, "  Invalid argument for --stripes: %s", stripes)
+			}
+			lv.segType = "striped"
+			lv.legs = n
+			lv.stripes = n
+		case "raid1", "raid5", "raid6", "raid10":
+			mirrors, stripes := 0, 1
+			if lvmType == "raid1" || lvmType == "raid10" {
+				value, ok := flag(flags, "-m", "--mirrors")
+				if !ok {
+					value = "1"
+				}
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 || lvmType == "raid10" && n > 1 {
+					return failf(3, "  Invalid argument for --mirrors: %s", value)
+				}
+				mirrors = n
+			}
+			if lvmType != "raid1" {
+				value, ok := flag(flags, "-i", "--stripes")
+				n, err := strconv.Atoi(value)
+				if !ok || err != nil || n < 2 || lvmType == "raid6" && n < 3 {
+					return failf(3, "  Invalid argument for --stripes: %s", value)
+				}
+				stripes = n
+			}
+			if _, ok := flags["--nosync"]; ok && (lvmType == "raid5" || lvmType == "raid6") {
+				return failf(3, "  nosync option prohibited on RAID layouts requiring parity")
+			}
+			lv.segType = lvmType
+			lv.attr = "rwi-a-r---"
+			if _, ok := flags["--nosync"]; ok {
+				lv.syncPercent = 100
+			}
+			switch lvmType {
+			case "raid1":
+				lv.legs = mirrors + 1
+			case "raid5":
+				lv.legs = stripes + 1
+			case "raid6":
+				lv.legs = stripes + 2
+			case "raid10":
+				lv.legs = stripes * (mirrors + 1)
+			}
+			// lvm reports the number of raid images as stripes
+			lv.stripes = lv.legs
+		default:
+			return failf(3, "  Segment type %s is not supported by the fake", lvmType)
+		}
+		if size, ok := flag(flags, "-I", "--stripesize"); ok {
+			n, err := parseSize(size)
+			if err != nil || n < 4096 || n&(n-1) != 0 || lv.dataLegs() == 1 {
+				return failf(3, "  Invalid stripe size %s", size)
+			}
+		}
+		if integrity, _ := flag(flags, "--raidintegrity"); integrity == "y" {
+			if !strings.HasPrefix(lv.segType, "raid") {
+				return failf(3, "  Integrity can only be added to raid images")
+			}
+			lv.integrity = true
+		}
+		if err := e.allocateSized(v, lv, flags); err != nil {
+			return err
+		}
+	}
+
+	v.lvs = append(v.lvs, lv)
+
+	return nil
+}
+
+// dataLegs returns the number of legs which hold distinct data, the others hold copies or parity
+func (lv *logicalVolume) dataLegs() int {
+	switch lv.segType {
+	case "striped":
+		return lv.legs
+	case "raid5":
+		return lv.legs - 1
+	case "raid6":
+		return lv.legs - 2
+	case "raid10":
+		return lv.legs / 2
+	default:
+		return 1
+	}
+}
+
+func roundUp(value int64, multiple int64) int64 {
+	return (value + multiple - 1) / multiple * multiple
+}
+
+// allocateSized allocates the extents for the -L argument, rounded up to full extents on every leg
+func (e *Executor) allocateSized(v *volumeGroup, lv *logicalVolume, flags map[string][]string) error {
+	value, ok := flag(flags, "-L", "--size")
+	if !ok {
+		return failf(3, "  Please specify either size or extents")
+	}
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+
+	data := int64(lv.dataLegs())
+	extents := roundUp(roundUp(size, DefaultExtentSize)/DefaultExtentSize, data)
+	perLeg := extents / data
+	if lv.integrity {
+		// the integrity metadata of every image takes about 1/128 of its data
+		perLeg += (perLeg + 127) / 128
+	}
+	if strings.HasPrefix(lv.segType, "raid") {
+		// every raid image has its own metadata extent
+		perLeg++
+	}
+
+	allocation, err := v.allocate(lv.legs, perLeg)
+	if err != nil {
+		return err
+	}
+
+	lv.size = extents * DefaultExtentSize
+	lv.allocation = allocation
+
+	return nil
+}
+
+func (e *Executor) lvextend(flags map[string][]string, positional []string) error {
+	if len(positional) != 1 {
+		return failf(3, "  Please specify a logical volume path")
+	}
+	v, name, err := e.target(positional[0])
+	if err != nil {
+		return err
+	}
+	lv := v.lv(name)
+	if lv == nil {
+		return failf(5, "  Logical volume %s not found in volume group %s", name, v.name)
+	}
+
+	value, _ := flag(flags, "-L", "--size")
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	data := int64(lv.dataLegs())
+	size = roundUp(size, DefaultExtentSize*data)
+	if size <= lv.size {
+		return failf(5, "  New size given (%d extents) not larger than existing size (%d extents)", size/DefaultExtentSize, lv.size/DefaultExtentSize)
+	}
+
+	if lv.segType != "thin" {
+		delta := (size - lv.size) / DefaultExtentSize / data
+		allocation, err := v.allocate(lv.legs, delta)
+		if err != nil {
+			return err
+		}
+		for pv, n := range allocation {
+			lv.allocation[pv] += n
+		}
+	}
+
+	lv.size = size
+
+	return nil
+}
+
+// lvchange only simulates adding and removing tags
+func (e *Executor) lvchange(flags map[string][]string, positional []string) error {
+	if len(positional) != 1 {
+		return failf(3, "  Please give logical volume path(s).")
+	}
+	v, name, err := e.target(positional[0])
+	if err != nil {
+		return err
+	}
+	lv := v.lv(name)
+	if lv == nil {
+		return failf(5, "  Failed to find logical volume \"%s/%s\"", v.name, name)
+	}
+
+	for _, tag := range flags["--addtag"] {
+		if !slices.Contains(lv.tags, tag) {
+			lv.tags = append(lv.tags, tag)
+		}
+	}
+	lv.tags = slices.DeleteFunc(lv.tags, func(tag string) bool {
+		return slices.Contains(flags["--deltag"], tag)
+	})
+
+	if permission, ok := flag(flags, "-p", "--permission"); ok {
+		switch permission {
+		case "r":
+			lv.attr = lv.attr[:1] + "r" + lv.attr[2:]
+		case "rw":
+			lv.attr = lv.attr[:1] + "w" + lv.attr[2:]
+		default:
+			return failf(3, "  Invalid argument for --permission: %s", permission)
+		}
+	}
+
+	return nil
+}
+
+func (e *Executor) lvremove(positional []string) error {
+	if len(positional) != 1 {
+		return failf(3, "  Please enter one or more logical volume paths")
+	}
+	v, name, err := e.target(positional[0])
+	if err != nil {
+		return err
+	}
+	lv := v.lv(name)
+	if lv == nil {
+		return failf(5, "  Failed to find logical volume \"%s/%s\"", v.name, name)
+	}
+
+	if _, ok := e.mountedAt(devicePath(v.name, name)); ok {
+		return failf(5, "  Logical volume %s/%s contains a filesystem in use.", v.name, name)
+	}
+
+	// like lvremove -y, snapshots of the volume and thin volumes of a pool are removed as well
+	v.lvs = slices.DeleteFunc(v.lvs, func(other *logicalVolume) bool {
+		if other == lv || other.origin == name || (lv.segType == "thin-pool" && other.pool == name) {
+			v.release(other)
+			delete(e.filesystems, devicePath(v.name, other.name))
+			delete(e.corruptions, devicePath(v.name, other.name))
+			return true
+		}
+		return false
+	})
+
+	return nil
+}
+
+func devicePath(vg string, lv string) string {
+	return path.Join("/dev", vg, lv)
+}
+
+// device returns true if the path is a known block device or logical volume
+func (e *Executor) device(p string) bool {
+	if _, ok := e.devices[p]; ok {
+		return true
+	}
+	vgName, lvName, ok := strings.Cut(strings.TrimPrefix(p, "/dev/"), "/")
+	if !ok {
+		return false
+	}
+	v, ok := e.vgs[vgName]
+	return ok && v.lv(lvName) != nil
+}
+
+// readOnly returns true if the device is a logical volume which was made read-only with lvchange --permission r
+func (e *Executor) readOnly(p string) bool {
+	vgName, lvName, ok := strings.Cut(strings.TrimPrefix(p, "/dev/"), "/")
+	if !ok {
+		return false
+	}
+	v, ok := e.vgs[vgName]
+	if !ok {
+		return false
+	}
+	lv := v.lv(lvName)
+	return lv != nil && lv.attr[1] == 'r'
+}
+
+func (e *Executor) lsblk(positional []string) (string, error) {
+	if len(positional) != 1 {
+		return "", failf(1, "lsblk: only one device is supported by the fake")
+	}
+	device := positional[0]
+	if !e.device(device) {
+		return "", failf(32, "lsblk: %s: not a block device", device)
+	}
+
+	var fsType *string
+	if f, ok := e.filesystems[device]; ok {
+		fsType = &f
+	}
+
+	majMin, kname := e.deviceNumber(device)
+
+	out, err := json.Marshal(map[string]any{
+		"blockdevices": []map[string]any{{"name": path.Base(device), "kname": kname, "maj:min": majMin, "fstype": fsType}},
+	})
+	return string(out), err
+}
+
+func (e *Executor) mkfs(fsType string, positional []string) error {
+	if len(positional) == 0 {
+		return failf(1, "mkfs.%s: no device specified", fsType)
+	}
+	device := positional[len(positional)-1]
+	if !e.device(device) {
+		return failf(1, "mkfs.%s: %s: No such file or directory", fsType, device)
+	}
+	if e.readOnly(device) {
+		return failf(1, "mkfs.%s: Read-only file system while trying to open %s", fsType, device)
+	}
+
+	e.filesystems[device] = fsType
+	delete(e.corruptions, device)
+	delete(e.dirty, device)
+
+	return nil
+}
+
+// mountedAt returns a target the device is mounted at, bind mounts of its device node included
+func (e *Executor) mountedAt(device string) (string, bool) {
+	for _, m := range e.mounts {
+		if m.device == device {
+			return m.target, true
+		}
+	}
+	return "", false
+}
+
+// lastMount returns the visible mount at target
+func (e *Executor) lastMount(target string) (mount, bool) {
+	for _, m := range slices.Backward(e.mounts) {
+		if m.target == target {
+			return m, true
+		}
+	}
+	return mount{}, false
+}
+
+func (e *Executor) mount(flags map[string][]string, positional []string) error {
+	if len(positional) != 2 {
+		return failf(1, "mount: source and target are required by the fake")
+	}
+	source, target := positional[0], positional[1]
+	if current, ok := e.lastMount(target); ok {
+		return failf(32, "mount: %s: %s already mounted on %s.", target, current.device, target)
+	}
+
+	var options []string
+	for _, o := range flags["-o"] {
+		options = append(options, strings.Split(o, ",")...)
+	}
+
+	_, bind := flags["--bind"]
+	// bind mounts of a mounted directory refer to the same filesystem
+	if m, ok := e.lastMount(source); ok && bind {
+		e.mounts = append(e.mounts, mount{target: target, device: m.device, node: m.node, options: options})
+		return nil
+	}
+	if !e.device(source) {
+		return failf(32, "mount: %s: special device %s does not exist.", target, source)
+	}
+	if existing, ok := e.filesystems[source]; !bind && (!ok || !mountable(flags["-t"], existing)) {
+		return failf(32, "mount: %s: wrong fs type, bad option, bad superblock on %s, missing codepage or helper program, or other error.", target, source)
+	}
+	// like mount(8) a filesystem of a write-protected device is mounted read-only
+	if e.readOnly(source) && !bind && !slices.Contains(options, "ro") {
+		options = append(slices.DeleteFunc(options, func(o string) bool { return o == "rw" }), "ro")
+	}
+
+	if !bind {
+		// the kernel replays the journal, even for read-only mounts
+		delete(e.dirty, source)
+	}
+	e.mounts = append(e.mounts, mount{target: target, device: source, node: bind, options: options})
+
+	return nil
+}
+
+// mountable returns true if a filesystem of type existing can be mounted with mount -t fsType, the ext4 driver also mounts ext2 and ext3
+func mountable(fsType []string, existing string) bool {
+	if len(fsType) == 0 {
+		return true
+	}
+	requested := fsType[len(fsType)-1]
+	return requested == existing || requested == "ext4" && strings.HasPrefix(existing, "ext")
+}
+
+// mountInfoEscaper escapes paths in the mount table like the kernel
+var mountInfoEscaper = strings.NewReplacer(" ", `\040`, "\t", `\011`, "\n", `\012`, `\`, `\134`)
+
+// mountInfo renders the mounts like /proc/self/mountinfo
+func (e *Executor) mountInfo() []byte {
+	var b strings.Builder
+	b.WriteString("22 1 0:21 / / rw,relatime shared:1 - overlay overlay rw\n")
+	for i, m := range e.mounts {
+		majMin, kname := e.deviceNumber(m.device)
+		root, fsType, source := "/", e.filesystems[m.device], mapperPath(m.device)
+		if m.node {
+			// bind mounts of a device node show the node inside of devtmpfs
+			majMin, root, fsType, source = "0:5", "/"+kname, "devtmpfs", "udev"
+		}
+
+		access := "rw"
+		var superOptions []string
+		for _, o := range m.options {
+			switch o {
+			case "ro", "rw":
+				access = o
+			default:
+				superOptions = append(superOptions, o)
+			}
+		}
+
+		fmt.Fprintf(&b, "%d 22 %s %s %s %s,relatime shared:%d - %s %s %s\n", 100+i, majMin, root, mountInfoEscaper.Replace(m.target), access, 100+i,
+			fsType, source, strings.Join(append([]string{access}, superOptions...), ","))
+	}
+	return []byte(b.String())
+}
+
+// deviceNumber returns the major:minor number and kernel name of the device, logical volumes are device mapper devices
+func (e *Executor) deviceNumber(device string) (string, string) {
+	n, ok := e.numbers[device]
+	if !ok {
+		n = len(e.numbers)
+		e.numbers[device] = n
+	}
+	if _, ok := e.devices[device]; ok {
+		return fmt.Sprintf("259:%d", n), path.Base(device)
+	}
+	return fmt.Sprintf("253:%d", n), fmt.Sprintf("dm-%d", n)
+}
+
+// mapperPath returns the path of a logical volume in /dev/mapper, which is how the kernel lists it in the mount table
+func mapperPath(device string) string {
+	vgName, lvName, ok := strings.Cut(strings.TrimPrefix(device, "/dev/"), "/")
+	if !ok {
+		return device
+	}
+	return "/dev/mapper/" + strings.ReplaceAll(vgName, "-", "--") + "-" + strings.ReplaceAll(lvName, "-", "--")
+}
+
+// inUse returns true if the filesystem of the device is mounted or an application opened it, bind mounts of the device node do not open it
+func (e *Executor) inUse(device string) bool {
+	if e.opened[device] {
+		return true
+	}
+	return slices.ContainsFunc(e.mounts, func(m mount) bool {
+		return m.device == device && !m.node
+	})
+}
+
+// OpenExclusive fails with EBUSY if the device is in use
+func (e *Executor) OpenExclusive(device string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.device(device) {
+		return &fs.PathError{Op: "open", Path: device, Err: fs.ErrNotExist}
+	}
+	if e.inUse(device) {
+		return &fs.PathError{Op: "open", Path: device, Err: syscall.EBUSY}
+	}
+	return nil
+}
+
+// ReadFile returns the simulated mount table for /proc/self/mountinfo, other files do not exist
+func (e *Executor) ReadFile(name string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if name != "/proc/self/mountinfo" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return e.mountInfo(), nil
+}
+
+func (e *Executor) umount(positional []string) error {
+	if len(positional) != 1 {
+		return failf(1, "umount: one target is required by the fake")
+	}
+	target := positional[0]
+	if _, ok := e.lastMount(target); !ok {
+		return failf(32, "umount: %s: not mounted.", target)
+	}
+
+	// like umount, only the filesystem which was mounted last is removed
+	for i := len(e.mounts) - 1; i >= 0; i-- {
+		if e.mounts[i].target == target {
+			e.mounts = slices.Delete(e.mounts, i, i+1)
+			break
+		}
+	}
+
+	return nil
+}
+
+// btrfs simulates btrfs check and btrfs filesystem resize, which only works for mounted filesystems
+func (e *Executor) btrfs(positional []string) error {
+	if len(positional) == 2 && positional[0] == "check" {
+		if e.filesystems[positional[1]] != "btrfs" {
+			return failf(1, "No valid Btrfs found on %s", positional[1])
+		}
+		if _, ok := e.corruptions[positional[1]]; ok {
+			return failf(1, "ERROR: errors found in fs roots")
+		}
+		return nil
+	}
+	if len(positional) != 4 || positional[0] != "filesystem" || positional[1] != "resize" {
+		return failf(1, "btrfs: only check and filesystem resize are supported by the fake")
+	}
+	target := positional[3]
+	m, ok := e.lastMount(target)
+	if !ok || m.node || e.filesystems[m.device] != "btrfs" {
+		return failf(1, "ERROR: not a btrfs filesystem: %s", target)
+	}
+
+	return nil
+}
+
+// fsck simulates e2fsck, xfs_repair and fsck.f2fs with their exit codes, they only repair if they are not called with -n or --dry-run.
+// e2fsck -E journal_only only replays the journal.
+func (e *Executor) fsck(command string, flags map[string][]string, positional []string) error {
+	if len(positional) != 1 {
+		return failf(16, "%s: one device is required by the fake", command)
+	}
+	device := positional[0]
+	fsTypes := map[string][]string{"e2fsck": {"ext2", "ext3", "ext4"}, "xfs_repair": {"xfs"}, "fsck.f2fs": {"f2fs"}}
+	if !slices.Contains(fsTypes[command], e.filesystems[device]) {
+		return failf(8, "%s: Bad magic number in super-block while trying to open %s", command, device)
+	}
+	if _, ok := e.mountedAt(device); ok {
+		return failf(8, "%s: %s is mounted.", command, device)
+	}
+
+	_, dryRun := flags["-n"]
+	if _, ok := flags["--dry-run"]; ok {
+		dryRun = true
+	}
+
+	if slices.Contains(flags["-E"], "journal_only") {
+		if !e.dirty[device] {
+			return nil
+		}
+		delete(e.dirty, device)
+		return failf(1, "%s: recovering journal", device)
+	}
+	if e.dirty[device] {
+		switch {
+		case dryRun && command == "e2fsck":
+			return failf(4, "Warning: skipping journal recovery because doing a read-only filesystem check.\n%s: ********** WARNING: Filesystem still has errors **********", device)
+		case dryRun:
+			return failf(1, "ALERT: The filesystem has valuable metadata changes in a log which is being ignored because the -n option was used.")
+		case command == "xfs_repair":
+			return failf(2, "ERROR: The filesystem has valuable metadata changes in a log which needs to be replayed.")
+		}
+		// e2fsck and fsck.f2fs recover the journal before they check the filesystem
+		delete(e.dirty, device)
+	}
+
+	repairable, corrupted := e.corruptions[device]
+	if !corrupted {
+		return nil
+	}
+
+	switch {
+	case dryRun && command == "e2fsck", !repairable && command == "e2fsck":
+		return failf(4, "%s: UNEXPECTED INCONSISTENCY; RUN fsck MANUALLY.", device)
+	case dryRun, !repairable:
+		return failf(1, "%s: filesystem has errors", device)
+	}
+
+	delete(e.corruptions, device)
+	if command == "e2fsck" {
+		// e2fsck exits with 1 if it corrected errors
+		return failf(1, "%s: FILE SYSTEM WAS MODIFIED", device)
+	}
+	return nil
+}
+
+// grow simulates resize2fs, which is given the device, and xfs_growfs, which is given the mount point
+func (e *Executor) grow(command string, positional []string) error {
+	if len(positional) != 1 {
+		return failf(1, "%s: one device or mount point is required by the fake", command)
+	}
+	device := positional[0]
+	if m, ok := e.lastMount(device); ok && !m.node {
+		device = m.device
+	}
+
+	switch {
+	case command == "resize2fs" && !slices.Contains([]string{"ext2", "ext3", "ext4"}, e.filesystems[device]):
+		return failf(1, "resize2fs: Bad magic number in super-block while trying to open %s", device)
+	case command == "xfs_growfs" && (e.filesystems[device] != "xfs" || device == positional[0]):
+		return failf(1, "xfs_growfs: %s is not a mounted XFS filesystem", positional[0])
+	}
+
+	return nil
+}
+
+// resizeF2FS simulates resize.f2fs, which refuses to grow mounted filesystems
+func (e *Executor) resizeF2FS(positional []string) error {
+	if len(positional) != 1 {
+		return failf(1, "resize.f2fs: one device is required by the fake")
+	}
+	device := positional[0]
+	if e.filesystems[device] != "f2fs" {
+		return failf(255, "\tInvalid SB CRC offset: 0\n\tCan't find a valid F2FS superblock at 0x0")
+	}
+	if _, ok := e.mountedAt(device); ok {
+		return failf(255, "\tError: Not available on mounted device!")
+	}
+
+	return nil
+}
+
+func (e *Executor) dd(positional []string) error {
+	operands := map[string]string{}
+	for _, arg := range positional {
+		key, value, _ := strings.Cut(arg, "=")
+		operands[key] = value
+	}
+
+	source, target := operands["if"], operands["of"]
+	if !e.device(source) {
+		return failf(1, "dd: failed to open '%s': No such file or directory", source)
+	}
+	if !e.device(target) {
+		return failf(1, "dd: failed to open '%s': No such file or directory", target)
+	}
+	if e.readOnly(target) {
+		return failf(1, "dd: failed to open '%s': Read-only file system", target)
+	}
+
+	if fsType, ok := e.filesystems[source]; ok {
+		e.filesystems[target] = fsType
+	} else {
+		delete(e.filesystems, target)
+	}
+
+	return nil
+}
+
+func report(kind string, rows []map[string]string) (string, error) {
+	if rows == nil {
+		rows = []map[string]string{}
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"report": []map[string]any{{kind: rows}},
+	})
+	return string(out), err
+}
+
+func (e *Executor) sortedVGs() []*volumeGroup {
+	return slices.SortedFunc(maps.Values(e.vgs), func(a, b *volumeGroup) int {
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+func (e *Executor) pvs(flags map[string][]string) (string, error) {
+	selected := ""
+	if selection, ok := flag(flags, "-S", "--select"); ok {
+		selected, _ = strings.CutPrefix(selection, "vg_name=")
+	}
+
+	var rows []map[string]string
+	for _, v := range e.sortedVGs() {
+		if selected != "" && v.name != selected {
+			continue
+		}
+		for _, pv := range v.pvs {
+			rows = append(rows, map[string]string{
+				"pv_name":           pv.name,
+				"vg_name":           v.name,
+				"pv_uuid":           pv.uuid,
+				"pv_size":           strconv.FormatInt(pv.extents*DefaultExtentSize, 10),
+				"pv_free":           strconv.FormatInt((pv.extents-pv.allocated)*DefaultExtentSize, 10),
+				"pv_attr":           "a--",
+				"pv_tags":           "",
+				"pv_pe_count":       strconv.FormatInt(pv.extents, 10),
+				"pv_pe_alloc_count": strconv.FormatInt(pv.allocated, 10),
+			})
+		}
+	}
+
+	return report("pv", rows)
+}
+
+func (e *Executor) vgsReport() (string, error) {
+	var rows []map[string]string
+	for _, v := range e.sortedVGs() {
+		var extents int64
+		for _, pv := range v.pvs {
+			extents += pv.extents
+		}
+		rows = append(rows, map[string]string{
+			"vg_name":         v.name,
+			"vg_uuid":         v.uuid,
+			"vg_size":         strconv.FormatInt(extents*DefaultExtentSize, 10),
+			"vg_free":         strconv.FormatInt(v.freeExtents()*DefaultExtentSize, 10),
+			"vg_attr":         "wz--n-",
+			"vg_tags":         strings.Join(v.tags, ","),
+			"vg_extent_size":  strconv.FormatInt(DefaultExtentSize, 10),
+			"vg_extent_count": strconv.FormatInt(extents, 10),
+			"vg_free_count":   strconv.FormatInt(v.freeExtents(), 10),
+			"pv_count":        strconv.Itoa(len(v.pvs)),
+			"lv_count":        strconv.Itoa(len(v.lvs)),
+		})
+	}
+
+	return report("vg", rows)
+}
+
+// lvAttr returns the attributes of the logical volume with the open flag set while its device is in use
+func (e *Executor) lvAttr(v *volumeGroup, lv *logicalVolume) string {
+	if len(lv.attr) < 6 || !e.inUse(devicePath(v.name, lv.name)) {
+		return lv.attr
+	}
+	return lv.attr[:5] + "o" + lv.attr[6:]
+}
+
+func (e *Executor) lvsReport(positional []string) (string, error) {
+	var vgs []*volumeGroup
+	if len(positional) == 0 {
+		vgs = e.sortedVGs()
+	}
+	for _, name := range positional {
+		v, ok := e.vgs[name]
+		if !ok {
+			return "", failf(5, "  Volume group %q not found", name)
+		}
+		vgs = append(vgs, v)
+	}
+
+	var rows []map[string]string
+	for _, v := range vgs {
+		for _, lv := range v.lvs {
+			row := map[string]string{
+				"lv_name":           lv.name,
+				"vg_name":           v.name,
+				"lv_uuid":           lv.uuid,
+				"lv_path":           devicePath(v.name, lv.name),
+				"lv_size":           strconv.FormatInt(lv.size, 10),
+				"lv_attr":           e.lvAttr(v, lv),
+				"lv_tags":           strings.Join(lv.tags, ","),
+				"segtype":           lv.segType,
+				"stripes":           strconv.Itoa(lv.stripes),
+				"origin":            lv.origin,
+				"origin_size":       "",
+				"pool_lv":           lv.pool,
+				"data_percent":      "",
+				"metadata_percent":  "",
+				"sync_percent":      "",
+				"lv_health_status":  "",
+				"raidintegritymode": "",
+				"lv_time":           lv.created.Format(lvTimeLayout),
+			}
+			if origin := v.lv(lv.origin); origin != nil {
+				row["origin_size"] = strconv.FormatInt(origin.size, 10)
+			}
+			switch {
+			case lv.segType == "thin-pool":
+				row["lv_path"] = ""
+				row["data_percent"] = strconv.FormatFloat(lv.dataPercent, 'f', 2, 64)
+				row["metadata_percent"] = strconv.FormatFloat(lv.metadataPercent, 'f', 2, 64)
+			case lv.segType == "thin", lv.origin != "":
+				row["data_percent"] = "0.00"
+			case strings.HasPrefix(lv.segType, "raid"):
+				row["sync_percent"] = strconv.FormatFloat(lv.syncPercent, 'f', 2, 64)
+			}
+			if lv.integrity {
+				row["raidintegritymode"] = "journal"
+			}
+			rows = append(rows, row)
+			// like lvs, linear volumes which span several physical volumes are reported with one row per segment
+			if lv.segType == "linear" {
+				for range len(lv.allocation) - 1 {
+					rows = append(rows, maps.Clone(row))
+				}
+			}
+		}
+	}
+
+	return report("lv", rows)
+}
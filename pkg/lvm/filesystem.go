@@ -0,0 +1,269 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Filesystems are the filesystem types which can be created on logical volumes and grown with them
+var Filesystems = []string{"ext3", "ext4", "xfs", "btrfs", "f2fs"}
+
+// defaultMkfsArgs are passed to mkfs in front of the arguments of the caller
+var defaultMkfsArgs = map[string][]string{
+	// the defaults of older btrfs-progs differ, a single device should always duplicate its metadata
+	"btrfs": {"--metadata", "dup", "--data", "single"},
+	// checksums are not enabled by default, they require the extra inode attributes
+	"f2fs": {"-O", "extra_attr,inode_checksum,sb_checksum"},
+}
+
+// growFilesystem grows the filesystem of the logical volume at lvPath to the size of the logical volume.
+// ext, xfs and btrfs are grown while they are mounted at mountPath, f2fs can only be grown while it is not mounted.
+func (c *Client) growFilesystem(ctx context.Context, fsType string, lvPath string, mountPath string) (string, error) {
+	switch fsType {
+	case "ext3", "ext4", "xfs":
+		if mountPath == "" {
+			// ext would have to be checked before it is grown offline and xfs is only grown online, both are grown once they are mounted
+			return "", nil
+		}
+		args := []string{"resize2fs", lvPath}
+		if fsType == "xfs" {
+			args = []string{"xfs_growfs", mountPath}
+		}
+		out, err := c.run(ctx, args[0], args[1:]...)
+		if err != nil {
+			return out, fmt.Errorf("unable to grow %s of %s at %s: %w (%s)", fsType, lvPath, mountPath, err, out)
+		}
+		return out, nil
+	case "btrfs":
+		if mountPath == "" {
+			// btrfs is grown while it is mounted
+			return "", nil
+		}
+		out, err := c.run(ctx, "btrfs", "filesystem", "resize", "max", mountPath)
+		if err != nil {
+			return out, fmt.Errorf("unable to grow btrfs of %s at %s: %w (%s)", lvPath, mountPath, err, out)
+		}
+		return out, nil
+	case "f2fs":
+		if mountPath != "" {
+			c.log.Info("f2fs can not be grown while it is mounted, it is grown when it is mounted the next time", "lv-path", lvPath)
+			return "", nil
+		}
+		// without a target size resize.f2fs grows the filesystem to the size of the device
+		out, err := c.run(ctx, "resize.f2fs", lvPath)
+		if err != nil {
+			return out, fmt.Errorf("unable to grow f2fs of %s: %w (%s)", lvPath, err, out)
+		}
+		return out, nil
+	default:
+		return "", nil
+	}
+}
+
+// ResizeFilesystemTag marks raw block volumes whose filesystem is grown together with the logical volume
+const ResizeFilesystemTag = "resize-fs.metal-stack.io/csi-lvm-driver"
+
+// growBlockFilesystem grows the filesystem of a raw block volume which is not in use. It is only grown if the device
+// can be opened exclusively, so it is neither mounted nor held by anyone else, ext, xfs and btrfs are mounted temporarily for it.
+func (c *Client) growBlockFilesystem(ctx context.Context, fsType string, lvPath string) (string, error) {
+	if err := c.exec.OpenExclusive(lvPath); err != nil {
+		return "", newError(ErrDeviceInUse, "block volume %s is in use, its filesystem can only be grown while the volume is not used: %v", lvPath, err)
+	}
+
+	switch fsType {
+	case "ext3", "ext4", "xfs", "btrfs":
+	case "f2fs":
+		return c.growFilesystem(ctx, fsType, lvPath, "")
+	default:
+		c.log.Info("filesystem of block volume can not be grown", "lv-path", lvPath, "fs-type", fsType)
+		return "", nil
+	}
+
+	return c.withTemporaryMount(ctx, fsType, lvPath, func(dir string) (string, error) {
+		return c.growFilesystem(ctx, fsType, lvPath, dir)
+	})
+}
+
+// withTemporaryMount mounts the filesystem of the device at a temporary directory, calls fn with it and unmounts it again
+func (c *Client) withTemporaryMount(ctx context.Context, fsType string, lvPath string, fn func(dir string) (string, error)) (string, error) {
+	dir, err := os.MkdirTemp("", "csi-lvm-mount-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary mount directory: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(dir)
+	}()
+
+	out, err := c.run(ctx, "mount", "-t", fsType, lvPath, dir)
+	if err != nil {
+		return out, fmt.Errorf("unable to mount %s temporarily: %w (%s)", lvPath, err, out)
+	}
+	defer func() {
+		// the filesystem must not stay mounted, even if the request was canceled
+		if err := c.Unmount(context.WithoutCancel(ctx), dir); err != nil {
+			c.log.Error("unable to unmount temporary mount", "lv-path", lvPath, "dir", dir, "error", err)
+		}
+	}()
+
+	return fn(dir)
+}
+
+// FsckPolicy decides what is done with an existing filesystem before it is mounted
+type FsckPolicy string
+
+const (
+	// FsckNever mounts the filesystem without checking it
+	FsckNever FsckPolicy = "never"
+	// FsckCheck checks the filesystem without changing it and refuses to mount it if errors are found
+	FsckCheck FsckPolicy = "check"
+	// FsckRepair repairs errors which can be repaired safely and refuses to mount the filesystem if errors remain
+	FsckRepair FsckPolicy = "repair"
+)
+
+// FsckResult is the outcome of a filesystem check without errors which were left behind
+type FsckResult struct {
+	FSType string
+	// Repaired is true if errors were found and repaired, xfs_repair does not tell if it repaired something
+	Repaired bool
+	Output   string
+}
+
+// fsckCommand returns the command which checks or repairs the filesystem type, false is returned if the type can not be checked.
+// btrfs is never repaired automatically, btrfs check --repair may make things worse.
+func fsckCommand(fsType string, lvPath string, policy FsckPolicy) (string, []string, bool) {
+	repair := policy == FsckRepair
+	switch fsType {
+	case "ext3", "ext4":
+		// filesystems which are marked clean are skipped without -f
+		if repair {
+			return "e2fsck", []string{"-p", lvPath}, true
+		}
+		return "e2fsck", []string{"-n", lvPath}, true
+	case "xfs":
+		if repair {
+			return "xfs_repair", []string{lvPath}, true
+		}
+		return "xfs_repair", []string{"-n", lvPath}, true
+	case "btrfs":
+		return "btrfs", []string{"check", "--readonly", lvPath}, true
+	case "f2fs":
+		if repair {
+			return "fsck.f2fs", []string{"-a", lvPath}, true
+		}
+		return "fsck.f2fs", []string{"--dry-run", lvPath}, true
+	default:
+		return "", nil, false
+	}
+}
+
+// replayJournal replays the journal of ext and the log of xfs like a mount would, after an unclean shutdown a check without
+// repairs would report the unreplayed changes as errors and xfs_repair refuses to repair. A journal which can not be replayed
+// is only logged, the check reports the errors of the filesystem then.
+func (c *Client) replayJournal(ctx context.Context, fsType string, lvPath string) error {
+	var (
+		out string
+		err error
+	)
+	switch fsType {
+	case "ext3", "ext4":
+		var stdout, stderr []byte
+		stdout, stderr, err = c.executeWithTimeout(ctx, 0, "e2fsck", "-E", "journal_only", lvPath)
+		out = string(stdout) + string(stderr)
+		// 1 means that the journal was replayed
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			c.log.Info("replayed journal", "lv-path", lvPath, "output", out)
+			err = nil
+		}
+	case "xfs":
+		out, err = c.withTemporaryMount(ctx, fsType, lvPath, func(string) (string, error) {
+			return "", nil
+		})
+	default:
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		c.log.Warn("unable to replay journal", "lv-path", lvPath, "fs-type", fsType, "error", err, "output", out)
+	}
+	return nil
+}
+
+// CheckFilesystem checks or repairs the filesystem of the logical volume according to the policy. Nothing is done and nil is
+// returned if the policy is never, the logical volume has no filesystem which can be checked or it is mounted somewhere.
+// ErrCorrupted is returned if errors were found and not repaired.
+func (c *Client) CheckFilesystem(ctx context.Context, vg string, name string, policy FsckPolicy) (*FsckResult, error) {
+	if policy == "" || policy == FsckNever {
+		return nil, nil
+	}
+	lvPath := fmt.Sprintf("/dev/%s/%s", vg, name)
+
+	dev, err := c.blockDevice(ctx, lvPath)
+	if err != nil {
+		return nil, err
+	}
+	if dev.FSType == nil {
+		return nil, nil
+	}
+	fsType := *dev.FSType
+
+	command, args, ok := fsckCommand(fsType, lvPath, policy)
+	if !ok {
+		c.log.Info("filesystem can not be checked", "lv-path", lvPath, "fs-type", fsType)
+		return nil, nil
+	}
+
+	// checking a mounted filesystem reports false errors, repairing it destroys it
+	mounts, err := c.mounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		if m.MajorMinor == dev.MajMin {
+			c.log.Debug("filesystem is mounted, skipping check", "lv-path", lvPath, "target", m.Target)
+			return nil, nil
+		}
+	}
+
+	err = c.replayJournal(ctx, fsType, lvPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.Info("checking filesystem", "lv-path", lvPath, "fs-type", fsType, "policy", policy)
+
+	// the duration of a check depends on the size of the filesystem, therefore only the context of the caller applies
+	stdout, stderr, err := c.executeWithTimeout(ctx, 0, command, args...)
+	result := &FsckResult{FSType: fsType, Output: string(stdout) + string(stderr)}
+	if err == nil {
+		return result, nil
+	}
+
+	var exitErr interface{ ExitCode() int }
+	if ctx.Err() != nil || !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("unable to check filesystem of %s: %w (%s)", lvPath, err, result.Output)
+	}
+
+	code := exitErr.ExitCode()
+	switch {
+	case command == "e2fsck" && code&^3 == 0:
+		// 1 and 2 mean that errors were corrected
+		result.Repaired = true
+		return result, nil
+	case command == "e2fsck" && code&^7 != 0:
+		// 8 and above are operational errors of e2fsck itself
+		return nil, fmt.Errorf("unable to check filesystem of %s: %w (%s)", lvPath, err, result.Output)
+	case command == "xfs_repair" && code == 2:
+		// the log has to be replayed by mounting the filesystem before it can be repaired
+		c.log.Warn("xfs log is dirty, it is replayed when the filesystem is mounted", "lv-path", lvPath)
+		return result, nil
+	}
+
+	c.log.Error("filesystem has errors", "lv-path", lvPath, "fs-type", fsType, "policy", policy, "exit-code", code, "output", result.Output)
+	return nil, newError(ErrCorrupted, "filesystem %s of %s has errors which were not repaired, %s exited with %d", fsType, lvPath, command, code)
+}
@@ -0,0 +1,146 @@
+package lvm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+)
+
+func TestCheckFilesystem(t *testing.T) {
+	const device = "/dev/" + testVG + "/pvc-1"
+
+	tests := []struct {
+		name         string
+		fsType       string
+		setup        func(t *testing.T, f *fake.Executor)
+		policy       lvm.FsckPolicy
+		wantErr      error
+		wantChecked  bool
+		wantRepaired bool
+	}{
+		{name: "never", fsType: "ext4", policy: lvm.FsckNever},
+		{name: "blank volume", policy: lvm.FsckCheck},
+		{name: "filesystem which can not be checked", fsType: "vfat", policy: lvm.FsckCheck},
+		{name: "healthy ext4", fsType: "ext4", policy: lvm.FsckCheck, wantChecked: true},
+		{name: "healthy xfs", fsType: "xfs", policy: lvm.FsckCheck, wantChecked: true},
+		{
+			name:   "unclean ext4",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.DirtyJournal(device)
+			},
+			policy:      lvm.FsckCheck,
+			wantChecked: true,
+		},
+		{
+			name:   "unclean xfs",
+			fsType: "xfs",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.DirtyJournal(device)
+			},
+			policy:      lvm.FsckCheck,
+			wantChecked: true,
+		},
+		{
+			name:   "unclean xfs is repaired",
+			fsType: "xfs",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.DirtyJournal(device)
+				f.Corrupt(device, true)
+			},
+			policy:      lvm.FsckRepair,
+			wantChecked: true,
+		},
+		{
+			name:   "unclean ext4 which can not be replayed",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.DirtyJournal(device)
+				f.Fail("e2fsck", "e2fsck: Bad magic number in super-block while checking journal")
+			},
+			policy:  lvm.FsckCheck,
+			wantErr: lvm.ErrCorrupted,
+		},
+		{
+			name:   "corrupted ext4 is only checked",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.Corrupt(device, true)
+			},
+			policy:  lvm.FsckCheck,
+			wantErr: lvm.ErrCorrupted,
+		},
+		{
+			name:   "corrupted ext4 is repaired",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.Corrupt(device, true)
+			},
+			policy:       lvm.FsckRepair,
+			wantChecked:  true,
+			wantRepaired: true,
+		},
+		{
+			name:   "corrupted ext4 which can not be repaired",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.Corrupt(device, false)
+			},
+			policy:  lvm.FsckRepair,
+			wantErr: lvm.ErrCorrupted,
+		},
+		{
+			name:   "corrupted btrfs is not repaired",
+			fsType: "btrfs",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.Corrupt(device, true)
+			},
+			policy:  lvm.FsckRepair,
+			wantErr: lvm.ErrCorrupted,
+		},
+		{
+			name:   "mounted filesystem",
+			fsType: "ext4",
+			setup: func(t *testing.T, f *fake.Executor) {
+				f.Corrupt(device, false)
+				_, _, err := f.Execute(context.Background(), "mount", device, t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			policy: lvm.FsckCheck,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, f := newTestClient(t, gib)
+			_, err := c.CreateLV(context.Background(), testVG, "pvc-1", uint64(100*mib), lvm.Layout{Type: "linear"}) //nolint:gosec
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fsType != "" {
+				_, _, err = f.Execute(context.Background(), "mkfs."+tt.fsType, device)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, f)
+			}
+
+			result, err := c.CheckFilesystem(context.Background(), testVG, "pvc-1", tt.policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if (result != nil) != tt.wantChecked {
+				t.Fatalf("got result %+v, want checked %t", result, tt.wantChecked)
+			}
+			if result != nil && result.Repaired != tt.wantRepaired {
+				t.Errorf("got repaired %t, want %t", result.Repaired, tt.wantRepaired)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFsckCommand(t *testing.T) {
+	const lvPath = "/dev/csi-lvm/pvc-1"
+
+	tests := []struct {
+		fsType      string
+		policy      FsckPolicy
+		wantCommand string
+		wantArgs    []string
+		wantOK      bool
+	}{
+		{fsType: "ext3", policy: FsckCheck, wantCommand: "e2fsck", wantArgs: []string{"-n", lvPath}, wantOK: true},
+		{fsType: "ext4", policy: FsckCheck, wantCommand: "e2fsck", wantArgs: []string{"-n", lvPath}, wantOK: true},
+		{fsType: "ext4", policy: FsckRepair, wantCommand: "e2fsck", wantArgs: []string{"-p", lvPath}, wantOK: true},
+		{fsType: "xfs", policy: FsckCheck, wantCommand: "xfs_repair", wantArgs: []string{"-n", lvPath}, wantOK: true},
+		{fsType: "xfs", policy: FsckRepair, wantCommand: "xfs_repair", wantArgs: []string{lvPath}, wantOK: true},
+		{fsType: "btrfs", policy: FsckCheck, wantCommand: "btrfs", wantArgs: []string{"check", "--readonly", lvPath}, wantOK: true},
+		{fsType: "btrfs", policy: FsckRepair, wantCommand: "btrfs", wantArgs: []string{"check", "--readonly", lvPath}, wantOK: true},
+		{fsType: "f2fs", policy: FsckCheck, wantCommand: "fsck.f2fs", wantArgs: []string{"--dry-run", lvPath}, wantOK: true},
+		{fsType: "f2fs", policy: FsckRepair, wantCommand: "fsck.f2fs", wantArgs: []string{"-a", lvPath}, wantOK: true},
+		{fsType: "vfat", policy: FsckCheck},
+		{fsType: "swap", policy: FsckRepair},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fsType+" "+string(tt.policy), func(t *testing.T) {
+			command, args, ok := fsckCommand(tt.fsType, lvPath, tt.policy)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tt.wantOK)
+			}
+			if command != tt.wantCommand || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("got %s %v, want %s %v", command, args, tt.wantCommand, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package lvm
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	linearType  = "linear"
+	stripedType = "striped"
+	// mirrorType is a raid1 with a single mirror, it is created as linear volume if the volume group has only one physical volume
+	mirrorType = "mirror"
+	raid1Type  = "raid1"
+	raid5Type  = "raid5"
+	raid6Type  = "raid6"
+	raid10Type = "raid10"
+
+	// minStripeSize is the smallest stripe size lvm accepts
+	minStripeSize = 4 << 10
+)
+
+// LayoutTypes are the lvm types of logical volumes which are created by CreateLV
+var LayoutTypes = []string{linearType, stripedType, mirrorType, raid1Type, raid5Type, raid6Type, raid10Type}
+
+// Layout describes how the extents of a logical volume are spread over the physical volumes of its volume group
+type Layout struct {
+	// Type is one of LayoutTypes
+	Type string
+	// Mirrors is the number of additional copies of raid1 and raid10 volumes, 0 selects the default
+	Mirrors int
+	// Stripes is the number of data stripes of striped, raid5, raid6 and raid10 volumes, 0 selects the default
+	Stripes int
+	// StripeSize is the size of a stripe in bytes, 0 lets lvm choose it
+	StripeSize int64
+	// Sync lets lvm synchronize the images of raid1 and raid10 volumes after they are created, otherwise
+	// they only contain the same data where it was written. raid5 and raid6 are always synchronized.
+	Sync bool
+	// Integrity adds dm-integrity to every image of raid volumes, which costs about 1/128 of the image for checksums
+	Integrity bool
+}
+
+// Validate returns an error if the layout is invalid regardless of the volume group
+func (l Layout) Validate() error {
+	if !slices.Contains(LayoutTypes, l.Type) {
+		return newError(ErrInvalidArgument, "lvmType is incorrect: %s", l.Type)
+	}
+	if l.Mirrors < 0 || l.Stripes < 0 || l.StripeSize < 0 {
+		return newError(ErrInvalidArgument, "mirrors, stripes and stripe size must not be negative")
+	}
+	if l.Mirrors > 0 && !l.mirrored() {
+		return newError(ErrInvalidArgument, "mirrors are not supported for lvm type %s", l.Type)
+	}
+	// lvm only supports two copies of every stripe
+	if l.Type == raid10Type && l.Mirrors > 1 {
+		return newError(ErrInvalidArgument, "raid10 supports only 1 mirror, got %d", l.Mirrors)
+	}
+	if (l.Stripes > 0 || l.StripeSize > 0) && !l.striped() {
+		return newError(ErrInvalidArgument, "stripes are not supported for lvm type %s", l.Type)
+	}
+	if minimum := l.minStripes(); l.Stripes > 0 && l.Stripes < minimum {
+		return newError(ErrInvalidArgument, "lvm type %s needs at least %d stripes, got %d", l.Type, minimum, l.Stripes)
+	}
+	if l.Sync && !l.IsRaid() {
+		return newError(ErrInvalidArgument, "synchronization is not supported for lvm type %s", l.Type)
+	}
+	if l.Integrity && !l.IsRaid() {
+		return newError(ErrInvalidArgument, "integrity is only supported for mirror and raid types")
+	}
+	if l.StripeSize > 0 && (l.StripeSize < minStripeSize || l.StripeSize&(l.StripeSize-1) != 0) {
+		return newError(ErrInvalidArgument, "stripe size must be a power of two of at least %d bytes, got %d", minStripeSize, l.StripeSize)
+	}
+	return nil
+}
+
+// Resolve returns the layout with the defaults for a volume group of pvCount physical volumes filled in.
+// An error is returned if the layout needs more physical volumes than the volume group has.
+// mirror and striped volumes without explicit mirrors or stripes become linear if there is only one physical volume.
+func (l Layout) Resolve(pvCount int) (Layout, error) {
+	if err := l.Validate(); err != nil {
+		return l, err
+	}
+
+	if pvCount < 2 && (l.Type == mirrorType && l.Mirrors == 0 || l.Type == stripedType && l.Stripes == 0) {
+		if l.Integrity {
+			return l, newError(ErrInvalidArgument, "integrity needs a mirror on a second physical volume, but the volume group has only %d", pvCount)
+		}
+		return Layout{Type: linearType}, nil
+	}
+
+	switch l.Type {
+	case mirrorType, raid1Type:
+		l.Mirrors = cmp.Or(l.Mirrors, 1)
+	case stripedType:
+		l.Stripes = cmp.Or(l.Stripes, pvCount)
+	case raid5Type:
+		l.Stripes = cmp.Or(l.Stripes, pvCount-1)
+	case raid6Type:
+		l.Stripes = cmp.Or(l.Stripes, pvCount-2)
+	case raid10Type:
+		l.Mirrors = 1
+		l.Stripes = cmp.Or(l.Stripes, pvCount/2)
+	}
+
+	if minimum := l.minStripes(); l.Stripes < minimum && l.striped() {
+		l.Stripes = minimum
+		return l, newError(ErrInvalidArgument, "lvm type %s needs at least %d physical volumes for %d stripes, but the volume group has only %d", l.Type, l.Images(), minimum, pvCount)
+	}
+	if images := l.Images(); images > pvCount {
+		return l, newError(ErrInvalidArgument, "lvm type %s with %d mirrors and %d stripes needs %d physical volumes, but the volume group has only %d", l.Type, l.Mirrors, l.Stripes, images, pvCount)
+	}
+
+	return l, nil
+}
+
+func (l Layout) mirrored() bool {
+	return l.Type == mirrorType || l.Type == raid1Type || l.Type == raid10Type
+}
+
+func (l Layout) striped() bool {
+	return l.Type == stripedType || l.Type == raid5Type || l.Type == raid6Type || l.Type == raid10Type
+}
+
+// IsRaid returns true if the logical volume is created as lvm raid, which is required for integrity
+func (l Layout) IsRaid() bool {
+	return l.Type != linearType && l.Type != stripedType
+}
+
+func (l Layout) minStripes() int {
+	switch l.Type {
+	case raid5Type, raid10Type:
+		return 2
+	case raid6Type:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Images returns the number of physical volumes the layout is spread over, every one holds a stripe or a copy of the data
+func (l Layout) Images() int {
+	switch l.Type {
+	case mirrorType, raid1Type:
+		return l.Mirrors + 1
+	case stripedType:
+		return l.Stripes
+	case raid5Type:
+		return l.Stripes + 1
+	case raid6Type:
+		return l.Stripes + 2
+	case raid10Type:
+		return l.Stripes * (l.Mirrors + 1)
+	default:
+		return 1
+	}
+}
+
+// dataImages returns how many of the images hold distinct data, the others hold copies or parity
+func (l Layout) dataImages() int {
+	if l.striped() {
+		return l.Stripes
+	}
+	return 1
+}
+
+// Matches returns true if the logical volume has the segment type, images and integrity of the resolved layout
+func (l Layout) Matches(lv *LogicalVolume) bool {
+	if l.Integrity != (lv.IntegrityMode != "") {
+		return false
+	}
+	if max(lv.Stripes, 1) != l.Images() {
+		return false
+	}
+
+	segType := l.Type
+	switch {
+	case l.Type == mirrorType:
+		segType = raid1Type
+	case l.Type == stripedType && l.Stripes == 1 && lv.SegType == linearType:
+		// lvm reports a single stripe as linear
+		return true
+	}
+	// raid5 and raid6 are reported with their parity layout like raid5_ls
+	return lv.SegType == segType || strings.HasPrefix(lv.SegType, segType+"_")
+}
+
+// RoundSize returns the size lvm allocates for a volume of the resolved layout with at least size bytes
+func (l Layout) RoundSize(size int64, extentSize int64) int64 {
+	return roundSize(size, extentSize, l.dataImages())
+}
+
+// roundSize rounds size up to at least one whole extent on each of data images
+func roundSize(size int64, extentSize int64, data int) int64 {
+	if extentSize <= 0 {
+		return size
+	}
+	multiple := extentSize * int64(max(data, 1))
+	return max((size+multiple-1)/multiple, 1) * multiple
+}
+
+// Capacity returns the capacity of the resolved layout in physical volumes with the given free extents.
+// available is the size of all volumes which fit together, maximum the size of the largest single volume.
+// Every image of a volume is placed on its own physical volume, only linear volumes may span several of them.
+func (l Layout) Capacity(freeExtents []int64, extentSize int64) (available int64, maximum int64) {
+	images := max(l.Images(), 1)
+	if images == 1 {
+		var sum int64
+		for _, free := range freeExtents {
+			sum += free
+		}
+		size := l.imageExtents(sum) * extentSize
+		return size, size
+	}
+	if len(freeExtents) < images {
+		return 0, 0
+	}
+
+	sorted := slices.Sorted(slices.Values(freeExtents))
+	slices.Reverse(sorted)
+
+	data := int64(l.dataImages())
+	available = l.imageExtents(spread(sorted, images)) * data * extentSize
+	// the smallest of the largest physical volumes limits every image of a single volume
+	maximum = l.imageExtents(sorted[images-1]) * data * extentSize
+	return available, maximum
+}
+
+// imageExtents returns the data extents of an image which can use free extents. raid images need one extent
+// for their metadata and integrity needs 1 of 129 extents for the checksums of the other 128.
+func (l Layout) imageExtents(free int64) int64 {
+	if l.IsRaid() {
+		free--
+	}
+	if l.Integrity {
+		free = free * 128 / 129
+	}
+	return max(free, 0)
+}
+
+// spread returns the largest number of extents which can be allocated for each of images on different physical volumes,
+// that is the largest n where the physical volumes can contribute at most n extents each to a total of images*n.
+func spread(freeExtents []int64, images int) int64 {
+	fits := func(n int64) bool {
+		var sum int64
+		for _, free := range freeExtents {
+			sum += min(free, n)
+		}
+		return sum >= n*int64(images)
+	}
+
+	var lo, hi int64
+	for _, free := range freeExtents {
+		hi += free
+	}
+	hi /= int64(images)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// args returns the lvcreate arguments of the resolved layout
+func (l Layout) args() []string {
+	var args []string
+	switch l.Type {
+	case linearType:
+		return nil
+	case mirrorType:
+		args = append(args, "--type", raid1Type)
+	default:
+		args = append(args, "--type", l.Type)
+	}
+	if l.mirrored() {
+		args = append(args, "--mirrors", strconv.Itoa(l.Mirrors))
+	}
+	if l.striped() {
+		args = append(args, "--stripes", strconv.Itoa(l.Stripes))
+	}
+	if l.StripeSize > 0 {
+		args = append(args, "--stripesize", strconv.Itoa(int(l.StripeSize>>10))+"k")
+	}
+	if l.Integrity {
+		args = append(args, "--raidintegrity", "y")
+	}
+	// the parity of raid5 and raid6 has to be calculated in any case
+	if l.mirrored() && !l.Sync {
+		args = append(args, "--nosync")
+	}
+	return args
+}
+
+// dataImagesOf returns the number of data images of a logical volume with the given segment type and images,
+// lvm reports the images of raid volumes as stripes. raid5 and raid6 are reported with their parity layout like raid5_ls.
+func dataImagesOf(segType string, images int) int {
+	switch {
+	case segType == stripedType:
+		return images
+	case strings.HasPrefix(segType, "raid4"), strings.HasPrefix(segType, raid5Type):
+		return max(images-1, 1)
+	case strings.HasPrefix(segType, raid6Type):
+		return max(images-2, 1)
+	case segType == raid10Type:
+		return max(images/2, 1)
+	default:
+		return 1
+	}
+}
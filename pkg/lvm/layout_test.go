@@ -0,0 +1,150 @@
+package lvm
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLayoutResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		layout   Layout
+		pvCount  int
+		want     Layout
+		wantArgs []string
+		wantErr  error
+	}{
+		{name: "linear", layout: Layout{Type: "linear"}, pvCount: 1, want: Layout{Type: "linear"}},
+		{name: "mirror", layout: Layout{Type: "mirror"}, pvCount: 2, want: Layout{Type: "mirror", Mirrors: 1}, wantArgs: []string{"--type", "raid1", "--mirrors", "1", "--nosync"}},
+		{name: "mirror on a single physical volume", layout: Layout{Type: "mirror"}, pvCount: 1, want: Layout{Type: "linear"}},
+		{name: "mirror with integrity on a single physical volume", layout: Layout{Type: "mirror", Integrity: true}, pvCount: 1, wantErr: ErrInvalidArgument},
+		{name: "synchronized mirror", layout: Layout{Type: "mirror", Sync: true}, pvCount: 2, want: Layout{Type: "mirror", Mirrors: 1, Sync: true}, wantArgs: []string{"--type", "raid1", "--mirrors", "1"}},
+		{name: "raid1 with two mirrors", layout: Layout{Type: "raid1", Mirrors: 2}, pvCount: 3, want: Layout{Type: "raid1", Mirrors: 2}, wantArgs: []string{"--type", "raid1", "--mirrors", "2", "--nosync"}},
+		{name: "raid1 with more mirrors than physical volumes", layout: Layout{Type: "raid1", Mirrors: 2}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "striped", layout: Layout{Type: "striped"}, pvCount: 3, want: Layout{Type: "striped", Stripes: 3}, wantArgs: []string{"--type", "striped", "--stripes", "3"}},
+		{name: "striped on a single physical volume", layout: Layout{Type: "striped"}, pvCount: 1, want: Layout{Type: "linear"}},
+		{name: "striped with more stripes than physical volumes", layout: Layout{Type: "striped", Stripes: 2}, pvCount: 1, wantErr: ErrInvalidArgument},
+		{name: "striped with stripe size", layout: Layout{Type: "striped", Stripes: 2, StripeSize: 64 << 10}, pvCount: 3, want: Layout{Type: "striped", Stripes: 2, StripeSize: 64 << 10}, wantArgs: []string{"--type", "striped", "--stripes", "2", "--stripesize", "64k"}},
+		{name: "raid5", layout: Layout{Type: "raid5"}, pvCount: 3, want: Layout{Type: "raid5", Stripes: 2}, wantArgs: []string{"--type", "raid5", "--stripes", "2"}},
+		{name: "raid5 with fewer stripes than physical volumes", layout: Layout{Type: "raid5", Stripes: 2}, pvCount: 4, want: Layout{Type: "raid5", Stripes: 2}, wantArgs: []string{"--type", "raid5", "--stripes", "2"}},
+		{name: "raid5 without enough physical volumes", layout: Layout{Type: "raid5"}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "raid6", layout: Layout{Type: "raid6"}, pvCount: 5, want: Layout{Type: "raid6", Stripes: 3}, wantArgs: []string{"--type", "raid6", "--stripes", "3"}},
+		{name: "raid6 without enough physical volumes", layout: Layout{Type: "raid6"}, pvCount: 4, wantErr: ErrInvalidArgument},
+		{name: "raid10", layout: Layout{Type: "raid10"}, pvCount: 4, want: Layout{Type: "raid10", Mirrors: 1, Stripes: 2}, wantArgs: []string{"--type", "raid10", "--mirrors", "1", "--stripes", "2", "--nosync"}},
+		{name: "raid10 on an odd number of physical volumes", layout: Layout{Type: "raid10"}, pvCount: 5, want: Layout{Type: "raid10", Mirrors: 1, Stripes: 2}, wantArgs: []string{"--type", "raid10", "--mirrors", "1", "--stripes", "2", "--nosync"}},
+		{name: "raid10 without enough physical volumes", layout: Layout{Type: "raid10"}, pvCount: 3, wantErr: ErrInvalidArgument},
+		{name: "raid10 with more stripes than physical volumes", layout: Layout{Type: "raid10", Stripes: 3}, pvCount: 4, wantErr: ErrInvalidArgument},
+		{name: "raid10 with two mirrors", layout: Layout{Type: "raid10", Mirrors: 2}, pvCount: 6, wantErr: ErrInvalidArgument},
+		{name: "raid with integrity", layout: Layout{Type: "raid5", Integrity: true}, pvCount: 3, want: Layout{Type: "raid5", Stripes: 2, Integrity: true}, wantArgs: []string{"--type", "raid5", "--stripes", "2", "--raidintegrity", "y"}},
+		{name: "striped with integrity", layout: Layout{Type: "striped", Integrity: true}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "mirrors of a striped volume", layout: Layout{Type: "striped", Mirrors: 1}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "stripes of a mirror", layout: Layout{Type: "mirror", Stripes: 2}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "stripe size which is no power of two", layout: Layout{Type: "striped", StripeSize: 48 << 10}, pvCount: 2, wantErr: ErrInvalidArgument},
+		{name: "unknown type", layout: Layout{Type: "raid7"}, pvCount: 8, wantErr: ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.layout.Resolve(tt.pvCount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("got layout %+v, want %+v", got, tt.want)
+			}
+			if args := got.args(); !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("got lvcreate args %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLayoutCapacity(t *testing.T) {
+	const extent = int64(4 << 20)
+
+	tests := []struct {
+		name          string
+		layout        Layout
+		freeExtents   []int64
+		wantAvailable int64
+		wantMaximum   int64
+	}{
+		{name: "linear spans all physical volumes", layout: Layout{Type: "linear"}, freeExtents: []int64{100, 50, 10}, wantAvailable: 160, wantMaximum: 160},
+		{name: "linear without physical volumes", layout: Layout{Type: "linear"}},
+		// two images of 60 extents fit, the first one on the large physical volume, the second one on the others
+		{name: "mirror", layout: Layout{Type: "mirror", Mirrors: 1}, freeExtents: []int64{100, 50, 10}, wantAvailable: 59, wantMaximum: 49},
+		{name: "mirror on a single physical volume", layout: Layout{Type: "mirror", Mirrors: 1}, freeExtents: []int64{100}},
+		{name: "mirror with integrity", layout: Layout{Type: "mirror", Mirrors: 1, Integrity: true}, freeExtents: []int64{256, 256}, wantAvailable: 253, wantMaximum: 253},
+		{name: "raid1 with more mirrors than physical volumes", layout: Layout{Type: "raid1", Mirrors: 2}, freeExtents: []int64{100, 50}},
+		{name: "striped over all physical volumes", layout: Layout{Type: "striped", Stripes: 3}, freeExtents: []int64{100, 50, 10}, wantAvailable: 30, wantMaximum: 30},
+		{name: "striped over some physical volumes", layout: Layout{Type: "striped", Stripes: 2}, freeExtents: []int64{100, 50, 10}, wantAvailable: 120, wantMaximum: 100},
+		{name: "raid5", layout: Layout{Type: "raid5", Stripes: 2}, freeExtents: []int64{100, 40, 100, 40}, wantAvailable: 158, wantMaximum: 78},
+		{name: "raid5 with integrity", layout: Layout{Type: "raid5", Stripes: 2, Integrity: true}, freeExtents: []int64{256, 256, 256}, wantAvailable: 506, wantMaximum: 506},
+		{name: "raid6", layout: Layout{Type: "raid6", Stripes: 3}, freeExtents: []int64{256, 256, 256, 256, 100}, wantAvailable: 297, wantMaximum: 297},
+		{name: "raid10", layout: Layout{Type: "raid10", Mirrors: 1, Stripes: 2}, freeExtents: []int64{100, 100, 50, 50, 20}, wantAvailable: 118, wantMaximum: 98},
+		{name: "full physical volume", layout: Layout{Type: "raid10", Mirrors: 1, Stripes: 2}, freeExtents: []int64{100, 100, 100, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			available, maximum := tt.layout.Capacity(tt.freeExtents, extent)
+			if available != tt.wantAvailable*extent {
+				t.Errorf("got available %d extents, want %d", available/extent, tt.wantAvailable)
+			}
+			if maximum != tt.wantMaximum*extent {
+				t.Errorf("got maximum %d extents, want %d", maximum/extent, tt.wantMaximum)
+			}
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	tests := []struct {
+		name        string
+		freeExtents []int64
+		images      int
+		want        int64
+	}{
+		{name: "equal physical volumes", freeExtents: []int64{10, 10}, images: 2, want: 10},
+		{name: "image on several physical volumes", freeExtents: []int64{100, 50, 10}, images: 2, want: 60},
+		{name: "image on every small physical volume", freeExtents: []int64{5, 5, 5, 5}, images: 2, want: 10},
+		{name: "smallest physical volume limits", freeExtents: []int64{30, 15, 10}, images: 3, want: 10},
+		{name: "one large physical volume", freeExtents: []int64{100, 1, 1}, images: 2, want: 2},
+		{name: "more images than physical volumes", freeExtents: []int64{10}, images: 2},
+		{name: "no physical volumes", images: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spread(tt.freeExtents, tt.images); got != tt.want {
+				t.Errorf("got %d extents per image, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageExtents(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout Layout
+		free   int64
+		want   int64
+	}{
+		{name: "linear", layout: Layout{Type: "linear"}, free: 100, want: 100},
+		{name: "striped", layout: Layout{Type: "striped", Stripes: 2}, free: 100, want: 100},
+		{name: "raid metadata", layout: Layout{Type: "raid1", Mirrors: 1}, free: 100, want: 99},
+		// 253 data extents need 2 extents of checksums and the metadata extent
+		{name: "integrity", layout: Layout{Type: "raid1", Mirrors: 1, Integrity: true}, free: 256, want: 253},
+		{name: "only metadata", layout: Layout{Type: "raid5", Stripes: 2}, free: 1},
+		{name: "no free extents", layout: Layout{Type: "raid5", Stripes: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.layout.imageExtents(tt.free); got != tt.want {
+				t.Errorf("got %d data extents, want %d", got, tt.want)
+			}
+		})
+	}
+}
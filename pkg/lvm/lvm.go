@@ -1,214 +1,297 @@
 package lvm
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 )
 
 const (
-	linearType  = "linear"
-	stripedType = "striped"
-	mirrorType  = "mirror"
+	snapshotTag = "snapshot.metal-stack.io/csi-lvm-driver"
+	// cloneSnapshotTag marks the temporary snapshots CloneLV reads from, they are no snapshots of a user
+	cloneSnapshotTag = "clone.metal-stack.io/csi-lvm-driver"
+
+	// CopyPendingTag marks logical volumes whose content is not completely copied from their content source yet
+	CopyPendingTag = "copy-pending.metal-stack.io/csi-lvm-driver"
+	// contentSourceTagPrefix is followed by the content source a logical volume was created from
+	contentSourceTagPrefix = "content-source.metal-stack.io/csi-lvm-driver="
 )
 
-type vgReport struct {
-	Report []struct {
-		VG []struct {
-			VGName string `json:"vg_name"`
-			VGFree string `json:"vg_free"`
-		} `json:"vg"`
-	} `json:"report"`
+// ContentSourceTag returns the tag which records that a logical volume was created from the given content source
+func ContentSourceTag(source string) string {
+	return contentSourceTagPrefix + source
 }
 
-type lsblk struct {
-	BlockDevices []struct {
-		FSType *string `json:"fstype"`
-	} `json:"blockdevices"`
-}
-
-func MountLV(log *slog.Logger, lvname, mountPath string, vgName string, fsType string) (string, error) {
+// MountLV formats the logical volume with mkfsArgs if it has no filesystem yet and mounts it with the given options at mountPath.
+// Nothing is done if the logical volume is already mounted there.
+func (c *Client) MountLV(ctx context.Context, lvname, mountPath string, vgName string, fsType string, mkfsArgs []string, options []string) (string, error) {
 	lvPath := fmt.Sprintf("/dev/%s/%s", vgName, lvname)
 
-	formatted := false
-	forceFormat := false
 	if fsType == "" {
 		fsType = "ext4"
 	}
-	// check for already formatted
-	cmd := exec.Command("lsblk", "-J", "-f", lvPath)
-	out, err := cmd.CombinedOutput()
+
+	dev, err := c.blockDevice(ctx, lvPath)
 	if err != nil {
-		return "", fmt.Errorf("unable to check if lv %s is already formatted: %w (%s)", lvPath, err, string(out))
+		return "", err
 	}
 
-	lsblkReport := lsblk{}
-	err = json.Unmarshal(out, &lsblkReport)
-
+	mounted, err := c.mountedFrom(ctx, mountPath, dev, options)
 	if err != nil {
-		return "", fmt.Errorf("failed to format lsblk output: %w", err)
+		return "", err
 	}
-
-	if len(lsblkReport.BlockDevices) != 1 {
-		return "", fmt.Errorf("unexpected amount of blockdevices found for lsblk (%d)", len(lsblkReport.BlockDevices))
+	if mounted {
+		c.log.Debug("lv already mounted", "lv-path", lvPath, "mount-path", mountPath)
+		return "", nil
 	}
 
-	switch f := lsblkReport.BlockDevices[0].FSType; f {
-	case nil:
+	formatted := false
+	forceFormat := false
+	switch f := dev.FSType; {
+	case f == nil:
 		formatted = false
-		log.Debug("lv not yet formatted", "lv-path", lvPath)
-	case new("xfs_external_log"):
+		c.log.Debug("lv not yet formatted", "lv-path", lvPath)
+	case *f == "xfs_external_log":
 		formatted = false
 		forceFormat = true
 	default:
 		formatted = true
-		log.Debug("lv already formatted", "lv-path", lvPath, "format", *f)
+		c.log.Debug("lv already formatted", "lv-path", lvPath, "format", *f)
 	}
 
-	if !formatted {
+	if !formatted && !slices.Contains(Filesystems, fsType) {
+		return "", newError(ErrInvalidArgument, "filesystem %s is not supported, supported are %s", fsType, strings.Join(Filesystems, ", "))
+	}
+
+	if formatted {
+		// existing filesystems are mounted as they are, even if another filesystem is requested now
+		fsType = *dev.FSType
+		// the logical volume may have been extended while the filesystem could not be grown because it was mounted
+		out, err := c.growFilesystem(ctx, *dev.FSType, lvPath, "")
+		if err != nil {
+			return out, err
+		}
+	} else {
 		formatArgs := []string{}
 		if forceFormat {
 			formatArgs = append(formatArgs, "-f")
 		}
+		formatArgs = append(formatArgs, defaultMkfsArgs[fsType]...)
+		formatArgs = append(formatArgs, mkfsArgs...)
 		formatArgs = append(formatArgs, lvPath)
 
-		log.Debug("formatting with mkfs", "fs-type", fsType, "args", strings.Join(formatArgs, " "))
-		cmd = exec.Command(fmt.Sprintf("mkfs.%s", fsType), formatArgs...) //nolint:gosec
-		out, err = cmd.CombinedOutput()
+		c.log.Debug("formatting with mkfs", "fs-type", fsType, "args", strings.Join(formatArgs, " "))
+		out, err := c.run(ctx, fmt.Sprintf("mkfs.%s", fsType), formatArgs...)
 		if err != nil {
-			return string(out), fmt.Errorf("unable to format lv %q: %w (%s)", lvname, err, string(out))
+			return out, fmt.Errorf("unable to format lv %q: %w (%s)", lvname, err, out)
 		}
 	}
 
-	err = os.MkdirAll(mountPath, 0777|os.ModeSetgid)
+	err = os.MkdirAll(mountPath, 0750)
 	if err != nil {
-		return string(out), fmt.Errorf("unable to create mount directory for lv:%s err:%w", lvname, err)
+		return "", fmt.Errorf("unable to create mount directory for lv:%s err:%w", lvname, err)
 	}
 
 	// --make-shared is required that this mount is visible outside this container.
-	mountArgs := []string{"--make-shared", "-t", fsType, lvPath, mountPath}
-	log.Debug("mounting with mount", "args", strings.Join(mountArgs, " "))
-	cmd = exec.Command("mount", mountArgs...)
-	out, err = cmd.CombinedOutput()
-	if err != nil {
-		mountOutput := string(out)
-		if !strings.Contains(mountOutput, "already mounted") {
-			return string(out), fmt.Errorf("unable to mount %q to %q: %w (%s)", lvPath, mountPath, err, string(out))
-		}
+	mountArgs := []string{"--make-shared", "-t", fsType}
+	if len(options) > 0 {
+		mountArgs = append(mountArgs, "-o", strings.Join(options, ","))
 	}
-	err = os.Chmod(mountPath, 0777|os.ModeSetgid)
+	mountArgs = append(mountArgs, lvPath, mountPath)
+	c.log.Debug("mounting with mount", "args", strings.Join(mountArgs, " "))
+	out, err := c.run(ctx, "mount", mountArgs...)
 	if err != nil {
-		return "", fmt.Errorf("unable to change permissions of volume mount %s err:%w", mountPath, err)
+		return out, fmt.Errorf("unable to mount %q to %q: %w (%s)", lvPath, mountPath, err, out)
 	}
-	log.Debug("mountlv output", "output", out)
+	c.log.Debug("mountlv output", "output", out)
 	return "", nil
 }
 
-func BindMountLV(log *slog.Logger, lvname, mountPath string, vgName string) (string, error) {
+// FilesystemType returns the filesystem on the logical volume, an empty string is returned if MountLV would format it
+func (c *Client) FilesystemType(ctx context.Context, vg string, name string) (string, error) {
+	dev, err := c.blockDevice(ctx, fmt.Sprintf("/dev/%s/%s", vg, name))
+	if err != nil {
+		return "", err
+	}
+	if dev.FSType == nil || *dev.FSType == "xfs_external_log" {
+		return "", nil
+	}
+	return *dev.FSType, nil
+}
+
+// BindMountLV makes the device of the logical volume available at mountPath with the given options.
+// Nothing is done if the device is already bind mounted there.
+func (c *Client) BindMountLV(ctx context.Context, lvname, mountPath string, vgName string, options []string) (string, error) {
 	lvPath := fmt.Sprintf("/dev/%s/%s", vgName, lvname)
-	_, err := os.Create(mountPath)
+
+	dev, err := c.blockDevice(ctx, lvPath)
 	if err != nil {
-		return "", fmt.Errorf("unable to create mount directory for lv:%s err:%w", lvname, err)
+		return "", err
 	}
 
+	mounted, err := c.mountedFrom(ctx, mountPath, dev, options)
+	if err != nil {
+		return "", err
+	}
+	if mounted {
+		c.log.Debug("lv already bind mounted", "lv-path", lvPath, "mount-path", mountPath)
+		return "", nil
+	}
+
+	f, err := os.OpenFile(mountPath, os.O_CREATE|os.O_RDONLY, 0660)
+	if err != nil {
+		return "", fmt.Errorf("unable to create mount file for lv:%s err:%w", lvname, err)
+	}
+	_ = f.Close()
+
 	// --make-shared is required that this mount is visible outside this container.
 	// --bind is required for raw block volumes to make them visible inside the pod.
-	mountArgs := []string{"--make-shared", "--bind", lvPath, mountPath}
-	log.Debug("bindmountlv command: mount", "args", strings.Join(mountArgs, " "))
-	cmd := exec.Command("mount", mountArgs...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		mountOutput := string(out)
-		if !strings.Contains(mountOutput, "already mounted") {
-			return string(out), fmt.Errorf("unable to mount %q to %s: %w (%s)", lvPath, mountPath, err, string(out))
-		}
+	mountArgs := []string{"--make-shared", "--bind"}
+	if len(options) > 0 {
+		mountArgs = append(mountArgs, "-o", strings.Join(options, ","))
 	}
-	err = os.Chmod(mountPath, 0777|os.ModeSetgid)
+	mountArgs = append(mountArgs, lvPath, mountPath)
+	c.log.Debug("bindmountlv command: mount", "args", strings.Join(mountArgs, " "))
+	out, err := c.run(ctx, "mount", mountArgs...)
 	if err != nil {
-		return "", fmt.Errorf("unable to change permissions of volume mount %s err:%w", mountPath, err)
+		return out, fmt.Errorf("unable to mount %q to %s: %w (%s)", lvPath, mountPath, err, out)
 	}
-	log.Debug("bindmountlv output", "output", out)
+	c.log.Debug("bindmountlv output", "output", out)
 	return "", nil
 }
 
-func UmountLV(log *slog.Logger, targetPath string) {
-	cmd := exec.Command("umount", "--lazy", "--force", targetPath)
-	out, err := cmd.CombinedOutput()
+// Unmount unmounts everything which is mounted at target, it is not an error if nothing is mounted there.
+// An error is returned if a filesystem is still mounted at target afterwards.
+func (c *Client) Unmount(ctx context.Context, target string) error {
+	mounts, err := c.mountsAt(ctx, target)
 	if err != nil {
-		//RETURN err ?
-		log.Error("unable to umount", "targetPath", targetPath, "output", string(out), "error", err)
+		return err
 	}
+
+	// filesystems which were mounted on top of each other are unmounted one by one
+	for range mounts {
+		out, err := c.run(ctx, "umount", target)
+		if err != nil {
+			return fmt.Errorf("unable to unmount %s: %w (%s)", target, err, out)
+		}
+	}
+
+	m, err := c.GetMount(ctx, target)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		return newError(ErrBusy, "%s is still mounted from %s", target, m.Source)
+	}
+
+	return nil
 }
 
-// VgExists checks if the given volume group exists
-func VgExists(log *slog.Logger, vgname string) bool {
-	cmd := exec.Command("vgs", vgname, "--noheadings", "-o", "vg_name")
-	out, err := cmd.CombinedOutput()
+// BindMount makes the mounted directory source also available at target with the given options,
+// filesystem specific options only apply to the mount of source. Nothing is done if source is already bind mounted there.
+func (c *Client) BindMount(ctx context.Context, source string, target string, options []string) (string, error) {
+	src, err := c.GetMount(ctx, source)
 	if err != nil {
-		log.Debug("unable to list existing volumegroups", "error", err, "output", string(out))
-		return false
+		return "", err
+	}
+	if src == nil {
+		return "", fmt.Errorf("%s is not mounted", source)
 	}
-	return vgname == strings.TrimSpace(string(out))
+
+	m, err := c.GetMount(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	if m != nil {
+		if m.MajorMinor != src.MajorMinor || m.Root != src.Root {
+			return "", newError(ErrMountConflict, "%s is already mounted from %s", target, m.Source)
+		}
+		if err := compatible(m, options); err != nil {
+			return "", err
+		}
+		c.log.Debug("already bind mounted", "source", source, "target", target)
+		return "", nil
+	}
+
+	err = os.MkdirAll(target, 0750)
+	if err != nil {
+		return "", fmt.Errorf("unable to create mount directory %s err:%w", target, err)
+	}
+
+	// --make-shared is required that this mount is visible outside this container.
+	mountArgs := []string{"--make-shared", "--bind"}
+	if len(options) > 0 {
+		mountArgs = append(mountArgs, "-o", strings.Join(options, ","))
+	}
+	mountArgs = append(mountArgs, source, target)
+	c.log.Debug("bindmount command: mount", "args", strings.Join(mountArgs, " "))
+	out, err := c.run(ctx, "mount", mountArgs...)
+	if err != nil {
+		return out, fmt.Errorf("unable to bind mount %q to %q: %w (%s)", source, target, err, out)
+	}
+	return "", nil
 }
 
 // VgActivate execute vgchange -ay to activate all volumes of the volume group
-func VgActivate(log *slog.Logger) {
+func (c *Client) VgActivate(ctx context.Context) {
 	// TODO: this function is kind of best effort and does not return any errors and it's not clear if it worked or not
 	// can we turn this into something idempotent and more concrete?
 
 	// scan for vgs and activate if any
-	cmd := exec.Command("vgscan")
-	out, err := cmd.CombinedOutput()
+	out, err := c.run(ctx, "vgscan")
 	if err != nil {
-		log.Debug("unable to scan for volumegroups", "output", string(out), "error", err)
+		c.log.Debug("unable to scan for volumegroups", "output", out, "error", err)
 	}
 
-	cmd = exec.Command("vgchange", "-ay")
-	_, err = cmd.CombinedOutput()
+	out, err = c.run(ctx, "vgchange", "-ay")
 	if err != nil {
-		log.Debug("unable to activate volumegroups", "output", string(out), "error", err)
+		c.log.Debug("unable to activate volumegroups", "output", out, "error", err)
 	}
 }
 
-func devices(log *slog.Logger, devicesPattern []string) (devices []string, err error) {
+func (c *Client) devices(devicesPattern []string) (devices []string, err error) {
 	for _, devicePattern := range devicesPattern {
-		log.Debug("search devices", "pattern", devicePattern)
+		c.log.Debug("search devices", "pattern", devicePattern)
 		matches, err := filepath.Glob(strings.TrimSpace(devicePattern))
 		if err != nil {
 			return nil, err
 		}
-		log.Debug("found devices", "matches", matches)
+		c.log.Debug("found devices", "matches", matches)
 		devices = append(devices, matches...)
 	}
 	return devices, nil
 }
 
 // CreateVG creates a volume group matching the given device patterns
-func CreateVG(log *slog.Logger, name string, devicesPattern string) (string, error) {
+func (c *Client) CreateVG(ctx context.Context, name string, devicesPattern string) (string, error) {
 	dp := strings.Split(devicesPattern, ",")
 	if len(dp) == 0 {
 		return name, fmt.Errorf("invalid empty flag %v", dp)
 	}
 
-	vgexists := VgExists(log, name)
-	if vgexists {
-		log.Info("volumegroup already exists", "name", name)
+	vg, err := c.GetVG(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if vg != nil {
+		c.log.Info("volumegroup already exists", "name", name)
 		return name, nil
 	}
-	VgActivate(log)
+	c.VgActivate(ctx)
 	// now check again for existing vg again
-	vgexists = VgExists(log, name)
-	if vgexists {
-		log.Info("volumegroup already exists", "name", name)
+	vg, err = c.GetVG(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if vg != nil {
+		c.log.Info("volumegroup already exists", "name", name)
 		return name, nil
 	}
 
-	physicalVolumes, err := devices(log, dp)
+	physicalVolumes, err := c.devices(dp)
 	if err != nil {
 		return "", fmt.Errorf("unable to lookup devices from devicesPattern %s, err:%w", devicesPattern, err)
 	}
@@ -219,172 +302,300 @@ func CreateVG(log *slog.Logger, name string, devicesPattern string) (string, err
 	for _, tag := range tags {
 		args = append(args, "--addtag", tag)
 	}
-	log.Debug("creating volumegroup", "name", name, "devices", physicalVolumes)
-	cmd := exec.Command("vgcreate", args...)
-	out, err := cmd.CombinedOutput()
-	return string(out), err
+	c.log.Debug("creating volumegroup", "name", name, "devices", physicalVolumes)
+	return c.run(ctx, "vgcreate", args...)
 }
 
-// CreateLV creates the new volume
+// CreateLV creates the new volume with the given layout and additional tags, the defaults of the layout are resolved for the volume group
 // used by lvcreate provisioner pod and by nodeserver for ephemeral volumes
-func CreateLV(log *slog.Logger, vg string, name string, size uint64, lvmType string, integrity bool) (string, error) {
-	if LvExists(log, vg, name) {
-		log.Debug("logicalvolume already exists", "name", name)
+func (c *Client) CreateLV(ctx context.Context, vg string, name string, size uint64, layout Layout, tags ...string) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
+	if err != nil {
+		return "", err
+	}
+	if lv != nil {
+		c.log.Debug("logicalvolume already exists", "name", name)
 		return name, nil
 	}
 
 	if size == 0 {
-		return "", fmt.Errorf("size must be greater than 0")
-	}
-
-	switch lvmType {
-	case "linear", "mirror", "striped":
-		// These are supported lvm types
-	default:
-		return "", fmt.Errorf("lvmType is incorrect: %s", lvmType)
+		return "", newError(ErrInvalidArgument, "size must be greater than 0")
 	}
 
 	args := []string{"-v", "--yes", "-n", name, "-W", "y", "-L", fmt.Sprintf("%db", size)}
 
-	pvs, err := pvCount(vg)
+	v, err := c.GetVG(ctx, vg)
 	if err != nil {
 		return "", fmt.Errorf("unable to determine pv count of vg: %w", err)
 	}
-
-	if pvs < 2 {
-		log.Warn("pvcount is <2 only linear is supported")
-		lvmType = linearType
+	if v == nil {
+		return "", newError(ErrNotFound, "volume group %s does not exist", vg)
 	}
 
-	switch lvmType {
-	case stripedType:
-		args = append(args, "--type", "striped", "--stripes", fmt.Sprintf("%d", pvs))
-	case mirrorType:
-		args = append(args, "--type", "raid1", "--mirrors", "1", "--nosync")
-	case linearType:
-	default:
-		return "", fmt.Errorf("unsupported lvmtype: %s", lvmType)
+	resolved, err := layout.Resolve(v.PVCount)
+	if err != nil {
+		return "", err
 	}
-
-	if integrity {
-		switch lvmType {
-		case mirrorType:
-			args = append(args, "--raidintegrity", "y")
-		default:
-			return "", fmt.Errorf("integrity is only supported if type is mirror")
-		}
+	if resolved.Type != layout.Type {
+		c.log.Warn("pvcount is <2 only linear is supported", "lvm-type", layout.Type)
 	}
+	args = append(args, resolved.args()...)
 
-	tags := []string{"lv.metal-stack.io/csi-lvm-driver"}
+	tags = append([]string{"lv.metal-stack.io/csi-lvm-driver"}, tags...)
 	for _, tag := range tags {
 		args = append(args, "--addtag", tag)
 	}
 	args = append(args, vg)
-	log.Debug("lvcreate", "args", args)
-	cmd := exec.Command("lvcreate", args...)
-	out, err := cmd.CombinedOutput()
-	return string(out), err
+	c.log.Debug("lvcreate", "args", args)
+	return c.run(ctx, "lvcreate", args...)
 }
 
-func LvExists(log *slog.Logger, vg string, name string) bool {
-	var (
-		vgname = vg + "/" + name
-		cmd    = exec.Command("lvs", vgname, "--noheadings", "-o", "lv_name")
-	)
+// ExtendLVS grows the logical volume to size together with the filesystem which is mounted at mountPath.
+// The content of raw block volumes, which have no mountPath, is only touched if they carry the ResizeFilesystemTag.
+func (c *Client) ExtendLVS(ctx context.Context, vg string, name string, size uint64, mountPath string) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
+	if err != nil {
+		return "", err
+	}
+	if lv == nil {
+		return "", newError(ErrNotFound, "logical volume %s does not exist", name)
+	}
+	lvPath := fmt.Sprintf("/dev/%s/%s", vg, name)
+
+	growBlock := mountPath == "" && lv.HasTag(ResizeFilesystemTag)
 
-	out, err := cmd.CombinedOutput()
+	fsType := ""
+	if mountPath != "" || growBlock {
+		dev, err := c.blockDevice(ctx, lvPath)
+		if err != nil {
+			return "", err
+		}
+		if dev.FSType != nil {
+			fsType = *dev.FSType
+		}
+	}
+
+	out := ""
+	resized := false
+	// a previous attempt or ControllerExpandVolume may have extended the logical volume already, the filesystem is grown anyway
+	if uint64(lv.Size) < size { //nolint:gosec
+		args := []string{"-L", fmt.Sprintf("%db", size)}
+		// fsadm which is called by lvextend -r does not know btrfs and f2fs
+		if mountPath != "" && fsType != "btrfs" && fsType != "f2fs" {
+			args = append(args, "-r")
+			resized = true
+		} else {
+			args = append(args, "-n")
+		}
+		args = append(args, fmt.Sprintf("%s/%s", vg, name))
+
+		c.log.Debug("lvextend", "args", args)
+
+		out, err = c.run(ctx, "lvextend", args...)
+		if err != nil {
+			return out, err
+		}
+	}
+
+	if growBlock {
+		if fsType == "" {
+			c.log.Info("block volume has no filesystem which can be grown", "lv-path", lvPath)
+			return out, nil
+		}
+		// the pod or a virtual machine may write to the filesystem without mounting it on the host
+		if lv.IsOpen() {
+			return out, newError(ErrDeviceInUse, "block volume %s is in use, its filesystem can only be grown while the volume is not used", lvPath)
+		}
+		grown, err := c.growBlockFilesystem(ctx, fsType, lvPath)
+		return out + grown, err
+	}
+
+	if resized {
+		return out, nil
+	}
+	grown, err := c.growFilesystem(ctx, fsType, lvPath, mountPath)
+	return out + grown, err
+}
+
+// ExtendLV grows the logical volume to size without touching its content, a logical volume
+// which is already large enough is left as it is.
+func (c *Client) ExtendLV(ctx context.Context, vg string, name string, size uint64) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
 	if err != nil {
-		log.Error("unable to list existing volumes", "error", err, "output", string(out))
-		return false
+		return "", err
+	}
+	if lv == nil {
+		return "", newError(ErrNotFound, "logical volume %s does not exist", name)
+	}
+	if uint64(lv.Size) >= size { //nolint:gosec
+		return "", nil
+	}
+
+	args := []string{"-L", fmt.Sprintf("%db", size), "-n", fmt.Sprintf("%s/%s", vg, name)}
+	c.log.Debug("lvextend", "args", args)
+	return c.run(ctx, "lvextend", args...)
+}
+
+// AddLVTag adds the tag to the logical volume, it is not an error if the logical volume already has it
+func (c *Client) AddLVTag(ctx context.Context, vg string, name string, tag string) (string, error) {
+	return c.run(ctx, "lvchange", "--addtag", tag, fmt.Sprintf("%s/%s", vg, name))
+}
+
+// RemoveLVTag removes the tag from the logical volume, it is not an error if the logical volume does not have it
+func (c *Client) RemoveLVTag(ctx context.Context, vg string, name string, tag string) (string, error) {
+	return c.run(ctx, "lvchange", "--deltag", tag, fmt.Sprintf("%s/%s", vg, name))
+}
+
+// SetLVPermission makes the device of the logical volume read-only or writable again,
+// lvm fails if the logical volume already has the requested permission.
+func (c *Client) SetLVPermission(ctx context.Context, vg string, name string, readOnly bool) (string, error) {
+	permission := "rw"
+	if readOnly {
+		permission = "r"
 	}
+	return c.run(ctx, "lvchange", "--permission", permission, fmt.Sprintf("%s/%s", vg, name))
+}
 
-	return name == strings.TrimSpace(string(out))
+// CopyLV copies the whole content of the logical volume sourceName to the logical volume targetName,
+// the target has to be at least as large as the source.
+func (c *Client) CopyLV(ctx context.Context, sourceVG string, sourceName string, targetVG string, targetName string) (string, error) {
+	args := []string{
+		fmt.Sprintf("if=/dev/%s/%s", sourceVG, sourceName),
+		fmt.Sprintf("of=/dev/%s/%s", targetVG, targetName),
+		"bs=4M",
+		"conv=fsync",
+	}
+	c.log.Debug("dd", "args", args)
+
+	// copying takes as long as the volume is large, therefore only the context of the caller applies
+	stdout, stderr, err := c.executeWithTimeout(ctx, 0, "dd", args...)
+	return string(stdout) + string(stderr), err
 }
 
-func ExtendLVS(log *slog.Logger, vg string, name string, size uint64, isBlock bool) (string, error) {
-	if !LvExists(log, vg, name) {
-		return "", fmt.Errorf("logical volume %s does not exist", name)
+// CreateCloneSnapshot creates the temporary snapshot of the logical volume sourceName which CloneLV copies into targetName,
+// so the source can stay in use while it is copied. A snapshot which was left behind by an interrupted clone is replaced,
+// it holds an older state of the source.
+func (c *Client) CreateCloneSnapshot(ctx context.Context, sourceVG string, sourceName string, targetName string) (string, error) {
+	snapshot := cloneSnapshotName(targetName)
+
+	out, err := c.RemoveSnapshot(ctx, sourceVG, snapshot)
+	if err != nil {
+		return out, fmt.Errorf("unable to remove temporary snapshot %s of an earlier attempt: %w", snapshot, err)
 	}
 
-	args := []string{"-L", fmt.Sprintf("%db", size)}
-	if isBlock {
-		args = append(args, "-n")
-	} else {
-		args = append(args, "-r")
+	out, err = c.createSnapshot(ctx, sourceVG, snapshot, sourceName, cloneSnapshotTag)
+	if err != nil {
+		return out, fmt.Errorf("unable to create temporary snapshot of %s: %w", sourceName, err)
 	}
-	args = append(args, fmt.Sprintf("%s/%s", vg, name))
+	return out, nil
+}
+
+// CloneLV copies the temporary snapshot created by CreateCloneSnapshot into the existing logical volume targetName,
+// the snapshot is removed afterwards, even if the copy failed.
+func (c *Client) CloneLV(ctx context.Context, sourceVG string, targetVG string, targetName string) (string, error) {
+	snapshot := cloneSnapshotName(targetName)
 
-	log.Debug("lvextend", "args", args)
+	defer func() {
+		// the temporary snapshot must be removed even if the copy was canceled
+		out, err := c.RemoveSnapshot(context.WithoutCancel(ctx), sourceVG, snapshot)
+		if err != nil {
+			c.log.Error("unable to remove temporary snapshot", "name", snapshot, "error", err, "output", out)
+		}
+	}()
 
-	cmd := exec.Command("lvextend", args...)
-	out, err := cmd.CombinedOutput()
+	return c.CopyLV(ctx, sourceVG, snapshot, targetVG, targetName)
+}
 
-	return string(out), err
+// cloneSnapshotName returns the name of the temporary snapshot a clone into targetName is copied from
+func cloneSnapshotName(targetName string) string {
+	return targetName + "-clone"
 }
 
 // RemoveLVS executes lvremove
-func RemoveLVS(log *slog.Logger, vg string, name string) (string, error) {
-	if !LvExists(log, vg, name) {
+func (c *Client) RemoveLVS(ctx context.Context, vg string, name string) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
+	if err != nil {
+		return "", err
+	}
+	if lv == nil {
 		return fmt.Sprintf("logical volume %s does not exist. Assuming it has already been deleted.", name), nil
 	}
 
 	args := []string{"-q", "-y"}
 	args = append(args, fmt.Sprintf("%s/%s", vg, name))
 
-	log.Debug("lvremove", "args", args)
+	c.log.Debug("lvremove", "args", args)
 
-	cmd := exec.Command("lvremove", args...)
-	out, err := cmd.CombinedOutput()
+	return c.run(ctx, "lvremove", args...)
+}
 
-	return string(out), err
+// CreateSnapshot creates a snapshot of the logical volume sourceName.
+// Snapshots of thick volumes are sized like their origin, so they can never run out of space,
+// snapshots of thin volumes are allocated from the thin pool.
+func (c *Client) CreateSnapshot(ctx context.Context, vg string, name string, sourceName string) (string, error) {
+	return c.createSnapshot(ctx, vg, name, sourceName, snapshotTag)
 }
 
-func pvCount(vgname string) (int, error) {
-	cmd := exec.Command("vgs", vgname, "--noheadings", "-o", "pv_count")
-	out, err := cmd.CombinedOutput()
+// createSnapshot creates the snapshot with the given tag, ListSnapshots only returns snapshots with the snapshotTag
+func (c *Client) createSnapshot(ctx context.Context, vg string, name string, sourceName string, tag string) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
 	if err != nil {
-		return 0, err
+		return "", err
+	}
+	if lv != nil {
+		c.log.Debug("snapshot already exists", "name", name)
+		return name, nil
 	}
 
-	outStr := strings.TrimSpace(string(out))
-	count, err := strconv.Atoi(outStr)
+	source, err := c.GetLV(ctx, vg, sourceName)
 	if err != nil {
-		return 0, err
+		return "", err
+	}
+	if source == nil {
+		return "", newError(ErrNotFound, "logical volume %s does not exist", sourceName)
 	}
 
-	return count, nil
+	args := []string{"-v", "--yes", "--snapshot", "-n", name}
+	if source.IsThin() {
+		// thin snapshots allocate from the pool, they are not activated by default
+		args = append(args, "--setactivationskip", "n")
+	} else {
+		args = append(args, "-L", fmt.Sprintf("%db", source.Size))
+	}
+	args = append(args, "--addtag", tag, fmt.Sprintf("%s/%s", vg, sourceName))
+	c.log.Debug("lvcreate", "args", args)
+	return c.run(ctx, "lvcreate", args...)
 }
 
-func VgStats(log *slog.Logger, vgName string) (int64, error) {
-	args := []string{vgName, "--units", "B", "--nosuffix", "--reportformat", "json"}
-	log.Debug("getting stats of vg", "vg-name", vgName, "args", strings.Join(args, " "))
-
-	cmd := exec.Command("vgs", args...) //nolint:gosec
-	out, err := cmd.CombinedOutput()
+// ListSnapshots returns all snapshots created by this driver in the given volume group
+func (c *Client) ListSnapshots(ctx context.Context, vg string) ([]LogicalVolume, error) {
+	lvs, err := c.ListLVs(ctx, vg)
 	if err != nil {
-		return 0, fmt.Errorf("unable to get vg stats of %q: %w (%s)", vgName, err, string(out))
+		return nil, err
 	}
 
-	pvReport := vgReport{}
-	err = json.Unmarshal(out, &pvReport)
-	if err != nil {
-		return 0, fmt.Errorf("failed to format vgs output: %w", err)
+	var snapshots []LogicalVolume
+	for _, lv := range lvs {
+		if lv.IsSnapshot() && lv.HasTag(snapshotTag) {
+			snapshots = append(snapshots, lv)
+		}
 	}
 
-	for _, report := range pvReport.Report {
-		for _, vg := range report.VG {
-			if vg.VGName != vgName {
-				continue
-			}
-
-			free, err := strconv.ParseInt(vg.VGFree, 10, 0)
-			if err != nil {
-				return 0, fmt.Errorf("failed to parse free space for device %s with error: %w", vg.VGName, err)
-			}
+	return snapshots, nil
+}
 
-			return free, nil
-		}
+// RemoveSnapshot removes the given snapshot, it is not an error if it does not exist anymore
+func (c *Client) RemoveSnapshot(ctx context.Context, vg string, name string) (string, error) {
+	lv, err := c.GetLV(ctx, vg, name)
+	if err != nil {
+		return "", err
 	}
+	if lv == nil || !lv.IsSnapshot() {
+		return fmt.Sprintf("snapshot %s does not exist. Assuming it has already been deleted.", name), nil
+	}
+
+	args := []string{"-q", "-y", fmt.Sprintf("%s/%s", vg, name)}
+	c.log.Debug("lvremove", "args", args)
 
-	return 0, fmt.Errorf("failed to find the free space for device %s", vgName)
+	return c.run(ctx, "lvremove", args...)
 }
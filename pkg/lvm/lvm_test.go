@@ -0,0 +1,65 @@
+package lvm_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+)
+
+func TestListPVsOfAllocatedVolumes(t *testing.T) {
+	// the physical volumes have 256, 128 and 64 extents of 4MiB, raid images need one extent for their metadata
+	tests := []struct {
+		name     string
+		layout   lvm.Layout
+		size     int64
+		wantFree []int64
+	}{
+		{name: "no volume"},
+		{name: "linear spans physical volumes", layout: lvm.Layout{Type: "linear"}, size: 1100 * mib, wantFree: []int64{0, 109, 64}},
+		{name: "mirror", layout: lvm.Layout{Type: "mirror", Mirrors: 1}, size: 508 * mib, wantFree: []int64{128, 0, 64}},
+		{name: "mirror with integrity", layout: lvm.Layout{Type: "mirror", Mirrors: 1, Integrity: true}, size: 200 * mib, wantFree: []int64{204, 76, 64}},
+		{name: "striped", layout: lvm.Layout{Type: "striped", Stripes: 2}, size: 400 * mib, wantFree: []int64{206, 78, 64}},
+		{name: "raid5", layout: lvm.Layout{Type: "raid5", Stripes: 2}, size: 200 * mib, wantFree: []int64{230, 102, 38}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(t, gib, 512*mib, 256*mib)
+
+			if tt.size > 0 {
+				_, err := c.CreateLV(context.Background(), testVG, "pvc-1", uint64(tt.size), tt.layout) //nolint:gosec
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pvs, err := c.ListPVs(context.Background(), testVG)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantFree := tt.wantFree
+			if wantFree == nil {
+				wantFree = []int64{256, 128, 64}
+			}
+			var free []int64
+			for _, pv := range pvs {
+				if pv.VGName != testVG {
+					t.Errorf("got physical volume %s of vg %s", pv.Name, pv.VGName)
+				}
+				if pv.Size != pv.ExtentCount*fake.DefaultExtentSize {
+					t.Errorf("got size %d of physical volume %s with %d extents", pv.Size, pv.Name, pv.ExtentCount)
+				}
+				if pv.Free != (pv.ExtentCount-pv.AllocatedExtentCount)*fake.DefaultExtentSize {
+					t.Errorf("got %d free bytes of physical volume %s with %d of %d extents allocated", pv.Free, pv.Name, pv.AllocatedExtentCount, pv.ExtentCount)
+				}
+				free = append(free, pv.ExtentCount-pv.AllocatedExtentCount)
+			}
+			if !slices.Equal(free, wantFree) {
+				t.Errorf("got free extents %v, want %v", free, wantFree)
+			}
+		})
+	}
+}
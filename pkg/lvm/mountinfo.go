@@ -0,0 +1,180 @@
+package lvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// mountInfoPath lists the mounts of the mount namespace of the plugin
+const mountInfoPath = "/proc/self/mountinfo"
+
+// Mount is a mounted filesystem as listed in /proc/self/mountinfo
+type Mount struct {
+	// MajorMinor is the device number of the mounted filesystem
+	MajorMinor string
+	// Root is the path inside the filesystem which is mounted at Target, it is not / for bind mounts of subdirectories or device nodes
+	Root   string
+	Target string
+	// Options are the options of this mount like ro or noatime, filesystem specific options are not included
+	Options []string
+	FSType  string
+	Source  string
+}
+
+// ReadOnly returns true if the filesystem is mounted read-only at Target
+func (m *Mount) ReadOnly() bool {
+	return slices.Contains(m.Options, "ro")
+}
+
+// mountInfoUnescaper reverts the octal escapes the kernel uses for paths in the mount table
+var mountInfoUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// parseMountInfo parses the mount table of the kernel, the format is described in proc(5)
+func parseMountInfo(data []byte) ([]Mount, error) {
+	var mounts []Mount
+	for line := range strings.Lines(string(data)) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// the optional fields after the mount options are terminated by a single hyphen
+		separator := slices.Index(fields, "-")
+		if separator < 6 || len(fields) < separator+3 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+
+		mounts = append(mounts, Mount{
+			MajorMinor: fields[2],
+			Root:       mountInfoUnescaper.Replace(fields[3]),
+			Target:     mountInfoUnescaper.Replace(fields[4]),
+			Options:    strings.Split(fields[5], ","),
+			FSType:     fields[separator+1],
+			Source:     mountInfoUnescaper.Replace(fields[separator+2]),
+		})
+	}
+
+	return mounts, nil
+}
+
+// mounts returns all mounts of the mount namespace of the plugin
+func (c *Client) mounts(ctx context.Context) ([]Mount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := c.exec.ReadFile(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mount table: %w", err)
+	}
+
+	return parseMountInfo(data)
+}
+
+// mountsAt returns all filesystems which are mounted at target, the visible one is the last
+func (c *Client) mountsAt(ctx context.Context, target string) ([]Mount, error) {
+	mounts, err := c.mounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	target = filepath.Clean(target)
+	return slices.DeleteFunc(mounts, func(m Mount) bool {
+		return m.Target != target
+	}), nil
+}
+
+// GetMount returns the filesystem which is mounted at target, nil is returned if nothing is mounted there
+func (c *Client) GetMount(ctx context.Context, target string) (*Mount, error) {
+	mounts, err := c.mountsAt(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, nil
+	}
+
+	// the last mount is the one which is visible if several filesystems are mounted on top of each other
+	return &mounts[len(mounts)-1], nil
+}
+
+type lsblk struct {
+	BlockDevices []blockDevice `json:"blockdevices"`
+}
+
+type blockDevice struct {
+	// KName is the name of the device node in /dev, like dm-0 for logical volumes
+	KName  string  `json:"kname"`
+	MajMin string  `json:"maj:min"`
+	FSType *string `json:"fstype"`
+}
+
+// blockDevice returns the device number and filesystem of the block device at path
+func (c *Client) blockDevice(ctx context.Context, path string) (*blockDevice, error) {
+	stdout, stderr, err := c.execute(ctx, "lsblk", "--json", "--nodeps", "--output", "KNAME,MAJ:MIN,FSTYPE", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect block device %s: %w (%s)", path, err, string(stderr))
+	}
+
+	report := lsblk{}
+	err = json.Unmarshal(stdout, &report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format lsblk output: %w", err)
+	}
+	if len(report.BlockDevices) != 1 {
+		return nil, fmt.Errorf("unexpected amount of blockdevices found for lsblk (%d)", len(report.BlockDevices))
+	}
+
+	return &report.BlockDevices[0], nil
+}
+
+// DeviceBindMounts returns the bind mounts of the device node of the logical volume, like the targets of published block volumes
+func (c *Client) DeviceBindMounts(ctx context.Context, vg string, name string) ([]Mount, error) {
+	dev, err := c.blockDevice(ctx, fmt.Sprintf("/dev/%s/%s", vg, name))
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := c.mounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(mounts, func(m Mount) bool {
+		return m.FSType != "devtmpfs" || m.Root != "/"+dev.KName
+	}), nil
+}
+
+// mountedFrom returns true if the device is mounted at target with compatible options,
+// false is returned if nothing is mounted at target and an error if something else is mounted there.
+func (c *Client) mountedFrom(ctx context.Context, target string, dev *blockDevice, options []string) (bool, error) {
+	m, err := c.GetMount(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	if m == nil {
+		return false, nil
+	}
+
+	// a filesystem of the device, or a bind mount of its device node which lives in devtmpfs
+	if m.MajorMinor != dev.MajMin && (m.FSType != "devtmpfs" || m.Root != "/"+dev.KName) {
+		return false, newError(ErrMountConflict, "%s is already mounted from %s", target, m.Source)
+	}
+	if err := compatible(m, options); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// compatible returns an error if the existing mount is read-write but options request read-only, or the other way round
+func compatible(m *Mount, options []string) error {
+	if m.ReadOnly() != slices.Contains(options, "ro") {
+		return newError(ErrMountConflict, "%s is already mounted with different access mode (%s)", m.Target, strings.Join(m.Options, ","))
+	}
+	return nil
+}
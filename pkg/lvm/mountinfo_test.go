@@ -0,0 +1,79 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Mount
+		wantErr bool
+	}{
+		{
+			name: "filesystem and bind mounts",
+			data: `22 1 0:21 / / rw,relatime shared:1 - overlay overlay rw
+100 22 253:0 / /var/lib/kubelet/staging rw,relatime shared:100 - ext4 /dev/mapper/csi--lvm-pvc--1 rw
+101 22 253:0 / /var/lib/kubelet/pods/p1/volumes/mount ro,relatime shared:100 - ext4 /dev/mapper/csi--lvm-pvc--1 rw
+102 22 0:5 /dm-1 /var/lib/kubelet/pods/p2/volumeDevices/pvc-2 rw,nosuid shared:2 - devtmpfs udev rw,size=4096k
+`,
+			want: []Mount{
+				{MajorMinor: "0:21", Root: "/", Target: "/", Options: []string{"rw", "relatime"}, FSType: "overlay", Source: "overlay"},
+				{MajorMinor: "253:0", Root: "/", Target: "/var/lib/kubelet/staging", Options: []string{"rw", "relatime"}, FSType: "ext4", Source: "/dev/mapper/csi--lvm-pvc--1"},
+				{MajorMinor: "253:0", Root: "/", Target: "/var/lib/kubelet/pods/p1/volumes/mount", Options: []string{"ro", "relatime"}, FSType: "ext4", Source: "/dev/mapper/csi--lvm-pvc--1"},
+				{MajorMinor: "0:5", Root: "/dm-1", Target: "/var/lib/kubelet/pods/p2/volumeDevices/pvc-2", Options: []string{"rw", "nosuid"}, FSType: "devtmpfs", Source: "udev"},
+			},
+		},
+		{
+			name: "without optional fields",
+			data: "100 22 253:0 / /mnt rw - xfs /dev/dm-0 rw\n",
+			want: []Mount{{MajorMinor: "253:0", Root: "/", Target: "/mnt", Options: []string{"rw"}, FSType: "xfs", Source: "/dev/dm-0"}},
+		},
+		{
+			name: "several optional fields",
+			data: "100 22 253:0 / /mnt rw shared:1 master:2 propagate_from:3 - xfs /dev/dm-0 rw\n",
+			want: []Mount{{MajorMinor: "253:0", Root: "/", Target: "/mnt", Options: []string{"rw"}, FSType: "xfs", Source: "/dev/dm-0"}},
+		},
+		{
+			name: "escaped paths",
+			data: `100 22 253:0 /a\134b /mnt/with\040space\011tab\012newline rw - ext4 /dev/my\040disk rw` + "\n",
+			want: []Mount{{MajorMinor: "253:0", Root: `/a\b`, Target: "/mnt/with space\ttab\nnewline", Options: []string{"rw"}, FSType: "ext4", Source: "/dev/my disk"}},
+		},
+		{
+			name: "blank lines",
+			data: "\n100 22 253:0 / /mnt rw - xfs /dev/dm-0 rw\n\n",
+			want: []Mount{{MajorMinor: "253:0", Root: "/", Target: "/mnt", Options: []string{"rw"}, FSType: "xfs", Source: "/dev/dm-0"}},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:    "missing separator",
+			data:    "100 22 253:0 / /mnt rw shared:1 xfs /dev/dm-0 rw\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing source",
+			data:    "100 22 253:0 / /mnt rw shared:1 - xfs\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing mount options",
+			data:    "100 22 253:0 / /mnt - xfs /dev/dm-0 rw\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMountInfo([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package lvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pvFields = "pv_name,vg_name,pv_uuid,pv_size,pv_free,pv_attr,pv_tags,pv_pe_count,pv_pe_alloc_count"
+	vgFields = "vg_name,vg_uuid,vg_size,vg_free,vg_attr,vg_tags,vg_extent_size,vg_extent_count,vg_free_count,pv_count,lv_count"
+	lvFields = "lv_name,vg_name,lv_uuid,lv_path,lv_size,lv_attr,lv_tags,segtype,stripes,origin,origin_size,pool_lv,data_percent,metadata_percent,sync_percent,lv_health_status,raidintegritymode,lv_time"
+
+	// lvTimeLayout is the format lvm uses for the lv_time field
+	lvTimeLayout = "2006-01-02 15:04:05 -0700"
+)
+
+// PhysicalVolume is a physical volume as reported by pvs
+type PhysicalVolume struct {
+	Name   string
+	VGName string
+	UUID   string
+	Size   int64
+	Free   int64
+	Attr   string
+	Tags   []string
+	// ExtentCount is the total number of physical extents
+	ExtentCount int64
+	// AllocatedExtentCount is the number of physical extents which are used by logical volumes
+	AllocatedExtentCount int64
+}
+
+// VolumeGroup is a volume group as reported by vgs
+type VolumeGroup struct {
+	Name            string
+	UUID            string
+	Size            int64
+	Free            int64
+	Attr            string
+	Tags            []string
+	ExtentSize      int64
+	ExtentCount     int64
+	FreeExtentCount int64
+	PVCount         int
+	LVCount         int
+}
+
+// LogicalVolume is a logical volume as reported by lvs
+type LogicalVolume struct {
+	Name   string
+	VGName string
+	UUID   string
+	Path   string
+	Size   int64
+	Attr   string
+	Tags   []string
+	// SegType is the segment type of the first segment, e.g. linear, striped, raid1 or thin.
+	// Volumes created by this driver only have segments of one type, even if they were extended on other physical volumes.
+	SegType string
+	Stripes int
+	// Origin is the name of the origin volume if this is a snapshot
+	Origin     string
+	OriginSize int64
+	// PoolLV is the name of the thin pool if this is a thin volume
+	PoolLV string
+	// DataPercent is the allocated percentage of thin volumes, thin pools and snapshots, -1 if not applicable
+	DataPercent float64
+	// MetadataPercent is the allocated percentage of the metadata of thin pools, -1 if not applicable
+	MetadataPercent float64
+	// SyncPercent is the synchronized percentage of raid volumes, -1 if not applicable
+	SyncPercent float64
+	// HealthStatus is empty for healthy volumes, otherwise for example partial, refresh needed or mismatches exist
+	HealthStatus string
+	// IntegrityMode is set if the raid images of this volume are protected by dm-integrity
+	IntegrityMode string
+	CreationTime  time.Time
+}
+
+// IsSnapshot returns true if the logical volume is a (possibly invalidated) snapshot
+func (lv *LogicalVolume) IsSnapshot() bool {
+	return lv.Origin != "" && (strings.HasPrefix(lv.Attr, "s") || strings.HasPrefix(lv.Attr, "S") || lv.SegType == "thin")
+}
+
+// IsInvalidSnapshot returns true if the snapshot ran out of space and was invalidated by lvm
+func (lv *LogicalVolume) IsInvalidSnapshot() bool {
+	return strings.HasPrefix(lv.Attr, "S")
+}
+
+// IsThin returns true if the logical volume is a thin volume
+func (lv *LogicalVolume) IsThin() bool {
+	return lv.SegType == "thin"
+}
+
+// IsOpen returns true if the device of the logical volume is opened, by a mount or any other process
+func (lv *LogicalVolume) IsOpen() bool {
+	return len(lv.Attr) > 5 && lv.Attr[5] == 'o'
+}
+
+// IsReadOnly returns true if the device of the logical volume can not be written
+func (lv *LogicalVolume) IsReadOnly() bool {
+	return len(lv.Attr) > 1 && lv.Attr[1] == 'r'
+}
+
+// IsSyncing returns true if the images of a raid volume are not in sync yet
+func (lv *LogicalVolume) IsSyncing() bool {
+	return lv.SyncPercent >= 0 && lv.SyncPercent < 100
+}
+
+// ContentSource returns the content source the logical volume was created from, empty if it was created empty
+func (lv *LogicalVolume) ContentSource() string {
+	for _, t := range lv.Tags {
+		if source, ok := strings.CutPrefix(t, contentSourceTagPrefix); ok {
+			return source
+		}
+	}
+	return ""
+}
+
+// HasTag returns true if the logical volume carries the given tag
+func (lv *LogicalVolume) HasTag(tag string) bool {
+	for _, t := range lv.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// RoundSize returns the size lvm allocates when the logical volume is extended to at least size bytes
+func (lv *LogicalVolume) RoundSize(size int64, extentSize int64) int64 {
+	return roundSize(size, extentSize, dataImagesOf(lv.SegType, max(lv.Stripes, 1)))
+}
+
+// ExtentsFor returns the number of physical extents the data of the logical volume occupies at the given size.
+// Striped and raid volumes grow by whole stripes on every image, integrity needs up to 4 bytes of metadata
+// for every 512 bytes of each image. The constant raid metadata is not included.
+// Thin volumes occupy extents of their thin pool only when they are written, 0 is returned for them.
+func (lv *LogicalVolume) ExtentsFor(size int64, extentSize int64) int64 {
+	if lv.IsThin() || extentSize <= 0 {
+		return 0
+	}
+
+	images := int64(max(lv.Stripes, 1))
+	data := int64(dataImagesOf(lv.SegType, int(images)))
+
+	// extents of every image
+	extents := ((size+extentSize-1)/extentSize + data - 1) / data
+	if lv.IntegrityMode != "" {
+		extents += (extents + 127) / 128
+	}
+	return extents * images
+}
+
+// CanExtend returns true if the logical volume can grow to size with the free extents of the physical volumes of its volume group.
+// Like in Layout.Capacity every image needs its additional extents on its own physical volume, only linear volumes may span several of them.
+func (lv *LogicalVolume) CanExtend(size int64, freeExtents []int64, extentSize int64) bool {
+	images := max(lv.Stripes, 1)
+	needed := (lv.ExtentsFor(size, extentSize) - lv.ExtentsFor(lv.Size, extentSize)) / int64(images)
+	return fitsImages(freeExtents, images, needed)
+}
+
+// FitsInto returns true if the logical volume could have size at all in physical volumes with the given extents,
+// the metadata extent of every raid image included.
+func (lv *LogicalVolume) FitsInto(size int64, extents []int64, extentSize int64) bool {
+	images := max(lv.Stripes, 1)
+	needed := lv.ExtentsFor(size, extentSize) / int64(images)
+	if strings.HasPrefix(lv.SegType, "raid") {
+		needed++
+	}
+	return fitsImages(extents, images, needed)
+}
+
+// fitsImages returns true if every one of images can get the extents on its own physical volume, a single image may span several of them
+func fitsImages(extents []int64, images int, perImage int64) bool {
+	if perImage <= 0 {
+		return true
+	}
+	if images == 1 {
+		var sum int64
+		for _, e := range extents {
+			sum += e
+		}
+		return sum >= perImage
+	}
+	if len(extents) < images {
+		return false
+	}
+	return spread(extents, images) >= perImage
+}
+
+type pvReport struct {
+	Report []struct {
+		PV []struct {
+			PVName         string `json:"pv_name"`
+			VGName         string `json:"vg_name"`
+			PVUUID         string `json:"pv_uuid"`
+			PVSize         string `json:"pv_size"`
+			PVFree         string `json:"pv_free"`
+			PVAttr         string `json:"pv_attr"`
+			PVTags         string `json:"pv_tags"`
+			PVPECount      string `json:"pv_pe_count"`
+			PVPEAllocCount string `json:"pv_pe_alloc_count"`
+		} `json:"pv"`
+	} `json:"report"`
+}
+
+type vgReport struct {
+	Report []struct {
+		VG []struct {
+			VGName        string `json:"vg_name"`
+			VGUUID        string `json:"vg_uuid"`
+			VGSize        string `json:"vg_size"`
+			VGFree        string `json:"vg_free"`
+			VGAttr        string `json:"vg_attr"`
+			VGTags        string `json:"vg_tags"`
+			VGExtentSize  string `json:"vg_extent_size"`
+			VGExtentCount string `json:"vg_extent_count"`
+			VGFreeCount   string `json:"vg_free_count"`
+			PVCount       string `json:"pv_count"`
+			LVCount       string `json:"lv_count"`
+		} `json:"vg"`
+	} `json:"report"`
+}
+
+type lvReport struct {
+	Report []struct {
+		LV []struct {
+			LVName            string `json:"lv_name"`
+			VGName            string `json:"vg_name"`
+			LVUUID            string `json:"lv_uuid"`
+			LVPath            string `json:"lv_path"`
+			LVSize            string `json:"lv_size"`
+			LVAttr            string `json:"lv_attr"`
+			LVTags            string `json:"lv_tags"`
+			SegType           string `json:"segtype"`
+			Stripes           string `json:"stripes"`
+			Origin            string `json:"origin"`
+			OriginSize        string `json:"origin_size"`
+			PoolLV            string `json:"pool_lv"`
+			DataPercent       string `json:"data_percent"`
+			MetadataPercent   string `json:"metadata_percent"`
+			SyncPercent       string `json:"sync_percent"`
+			LVHealthStatus    string `json:"lv_health_status"`
+			RaidIntegrityMode string `json:"raidintegritymode"`
+			LVTime            string `json:"lv_time"`
+		} `json:"lv"`
+	} `json:"report"`
+}
+
+// ListPVs returns all physical volumes of the given volume group, or all physical volumes if vg is empty
+func (c *Client) ListPVs(ctx context.Context, vg string) ([]PhysicalVolume, error) {
+	args := []string{"--units", "b", "--nosuffix", "--reportformat", "json", "-o", pvFields}
+	if vg != "" {
+		args = append(args, "-S", "vg_name="+vg)
+	}
+
+	report := pvReport{}
+	err := c.runReport(ctx, "pvs", args, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	var pvs []PhysicalVolume
+	for _, r := range report.Report {
+		for _, raw := range r.PV {
+			p := parser{}
+			pvs = append(pvs, PhysicalVolume{
+				Name:                 raw.PVName,
+				VGName:               raw.VGName,
+				UUID:                 raw.PVUUID,
+				Size:                 p.int64("pv_size", raw.PVSize),
+				Free:                 p.int64("pv_free", raw.PVFree),
+				Attr:                 raw.PVAttr,
+				Tags:                 parseTags(raw.PVTags),
+				ExtentCount:          p.int64("pv_pe_count", raw.PVPECount),
+				AllocatedExtentCount: p.int64("pv_pe_alloc_count", raw.PVPEAllocCount),
+			})
+			if p.err != nil {
+				return nil, fmt.Errorf("unable to parse physical volume %s: %w", raw.PVName, p.err)
+			}
+		}
+	}
+
+	return pvs, nil
+}
+
+// ListVGs returns all volume groups
+func (c *Client) ListVGs(ctx context.Context) ([]VolumeGroup, error) {
+	args := []string{"--units", "b", "--nosuffix", "--reportformat", "json", "-o", vgFields}
+
+	report := vgReport{}
+	err := c.runReport(ctx, "vgs", args, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	var vgs []VolumeGroup
+	for _, r := range report.Report {
+		for _, raw := range r.VG {
+			p := parser{}
+			vgs = append(vgs, VolumeGroup{
+				Name:            raw.VGName,
+				UUID:            raw.VGUUID,
+				Size:            p.int64("vg_size", raw.VGSize),
+				Free:            p.int64("vg_free", raw.VGFree),
+				Attr:            raw.VGAttr,
+				Tags:            parseTags(raw.VGTags),
+				ExtentSize:      p.int64("vg_extent_size", raw.VGExtentSize),
+				ExtentCount:     p.int64("vg_extent_count", raw.VGExtentCount),
+				FreeExtentCount: p.int64("vg_free_count", raw.VGFreeCount),
+				PVCount:         int(p.int64("pv_count", raw.PVCount)),
+				LVCount:         int(p.int64("lv_count", raw.LVCount)),
+			})
+			if p.err != nil {
+				return nil, fmt.Errorf("unable to parse volume group %s: %w", raw.VGName, p.err)
+			}
+		}
+	}
+
+	return vgs, nil
+}
+
+// GetVG returns the volume group with the given name, nil is returned if it does not exist
+func (c *Client) GetVG(ctx context.Context, name string) (*VolumeGroup, error) {
+	vgs, err := c.ListVGs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vg := range vgs {
+		if vg.Name == name {
+			return &vg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ListLVs returns all logical volumes of the given volume group
+func (c *Client) ListLVs(ctx context.Context, vg string) ([]LogicalVolume, error) {
+	args := []string{vg, "--units", "b", "--nosuffix", "--reportformat", "json", "-o", lvFields}
+
+	report := lvReport{}
+	err := c.runReport(ctx, "lvs", args, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	var lvs []LogicalVolume
+	// the segment fields let lvs report one row for every segment, only the first one of a volume is kept
+	seen := map[string]bool{}
+	for _, r := range report.Report {
+		for _, raw := range r.LV {
+			if seen[raw.LVUUID] {
+				continue
+			}
+			seen[raw.LVUUID] = true
+
+			p := parser{}
+			lvs = append(lvs, LogicalVolume{
+				Name:            raw.LVName,
+				VGName:          raw.VGName,
+				UUID:            raw.LVUUID,
+				Path:            raw.LVPath,
+				Size:            p.int64("lv_size", raw.LVSize),
+				Attr:            raw.LVAttr,
+				Tags:            parseTags(raw.LVTags),
+				SegType:         raw.SegType,
+				Stripes:         int(p.int64("stripes", raw.Stripes)),
+				Origin:          raw.Origin,
+				OriginSize:      p.int64("origin_size", raw.OriginSize),
+				PoolLV:          raw.PoolLV,
+				DataPercent:     p.percent("data_percent", raw.DataPercent),
+				MetadataPercent: p.percent("metadata_percent", raw.MetadataPercent),
+				SyncPercent:     p.percent("sync_percent", raw.SyncPercent),
+				HealthStatus:    raw.LVHealthStatus,
+				IntegrityMode:   raw.RaidIntegrityMode,
+				CreationTime:    p.time("lv_time", raw.LVTime),
+			})
+			if p.err != nil {
+				return nil, fmt.Errorf("unable to parse logical volume %s: %w", raw.LVName, p.err)
+			}
+		}
+	}
+
+	return lvs, nil
+}
+
+// GetLV returns the logical volume with the given name, nil is returned if it does not exist
+func (c *Client) GetLV(ctx context.Context, vg string, name string) (*LogicalVolume, error) {
+	lvs, err := c.ListLVs(ctx, vg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lv := range lvs {
+		if lv.Name == name {
+			return &lv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *Client) runReport(ctx context.Context, command string, args []string, report any) error {
+	c.log.Debug("getting lvm report", "command", command, "args", strings.Join(args, " "))
+
+	stdout, stderr, err := c.execute(ctx, command, args...)
+	if err != nil {
+		return fmt.Errorf("unable to run %s: %w (%s)", command, err, string(stderr))
+	}
+
+	err = json.Unmarshal(stdout, report)
+	if err != nil {
+		return fmt.Errorf("failed to format %s output: %w", command, err)
+	}
+
+	return nil
+}
+
+func parseTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// parser converts the string values of lvm reports and remembers the first error
+type parser struct {
+	err error
+}
+
+func (p *parser) int64(field string, value string) int64 {
+	if value == "" || p.err != nil {
+		return 0
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		p.err = fmt.Errorf("invalid value %q for %s: %w", value, field, err)
+	}
+	return i
+}
+
+func (p *parser) percent(field string, value string) float64 {
+	if value == "" || p.err != nil {
+		return -1
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.err = fmt.Errorf("invalid value %q for %s: %w", value, field, err)
+	}
+	return f
+}
+
+func (p *parser) time(field string, value string) time.Time {
+	if value == "" || p.err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(lvTimeLayout, value)
+	if err != nil {
+		p.err = fmt.Errorf("invalid value %q for %s: %w", value, field, err)
+	}
+	return t
+}
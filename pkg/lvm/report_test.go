@@ -0,0 +1,290 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// reportExecutor returns the same output for every command
+type reportExecutor struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+func (e reportExecutor) Execute(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	return []byte(e.stdout), []byte(e.stderr), e.err
+}
+
+func (e reportExecutor) ReadFile(name string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e reportExecutor) OpenExclusive(device string) error {
+	return errors.New("not implemented")
+}
+
+func reportClient(e reportExecutor) *Client {
+	return New(slog.New(slog.DiscardHandler), e, time.Second)
+}
+
+func TestListLVs(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("", 3600))
+
+	tests := []struct {
+		name    string
+		exec    reportExecutor
+		want    []LogicalVolume
+		wantErr bool
+	}{
+		{
+			name: "all fields",
+			exec: reportExecutor{stdout: `{"report": [{"lv": [
+				{"lv_name":"pvc-1", "vg_name":"csi-lvm", "lv_uuid":"u1", "lv_path":"/dev/csi-lvm/pvc-1", "lv_size":"104857600", "lv_attr":"rwi-aor---",
+				 "lv_tags":"lv.metal-stack.io/csi-lvm-driver,resize-fs.metal-stack.io/csi-lvm-driver", "segtype":"raid1", "stripes":"2", "origin":"", "origin_size":"",
+				 "pool_lv":"", "data_percent":"", "metadata_percent":"", "sync_percent":"42.50", "lv_health_status":"", "raidintegritymode":"journal", "lv_time":"2024-03-01 12:30:00 +0100"}
+			]}]}`},
+			want: []LogicalVolume{{
+				Name: "pvc-1", VGName: "csi-lvm", UUID: "u1", Path: "/dev/csi-lvm/pvc-1", Size: 104857600, Attr: "rwi-aor---",
+				Tags:    []string{"lv.metal-stack.io/csi-lvm-driver", "resize-fs.metal-stack.io/csi-lvm-driver"},
+				SegType: "raid1", Stripes: 2, DataPercent: -1, MetadataPercent: -1, SyncPercent: 42.5, IntegrityMode: "journal", CreationTime: created,
+			}},
+		},
+		{
+			name: "thin pool and snapshot",
+			exec: reportExecutor{stdout: `{"report": [{"lv": [
+				{"lv_name":"csi-lvm-thinpool", "vg_name":"csi-lvm", "lv_uuid":"u1", "lv_size":"1073741824", "lv_attr":"twi-aotz--", "segtype":"thin-pool", "stripes":"1",
+				 "data_percent":"12.34", "metadata_percent":"5.00", "lv_time":"2024-03-01 12:30:00 +0100"},
+				{"lv_name":"snap-1", "vg_name":"csi-lvm", "lv_uuid":"u2", "lv_size":"104857600", "lv_attr":"swi-a-s---", "segtype":"linear", "stripes":"1",
+				 "origin":"pvc-1", "origin_size":"104857600", "data_percent":"0.01", "lv_time":"2024-03-01 12:30:00 +0100"}
+			]}]}`},
+			want: []LogicalVolume{
+				{Name: "csi-lvm-thinpool", VGName: "csi-lvm", UUID: "u1", Size: 1073741824, Attr: "twi-aotz--", SegType: "thin-pool", Stripes: 1,
+					DataPercent: 12.34, MetadataPercent: 5, SyncPercent: -1, CreationTime: created},
+				{Name: "snap-1", VGName: "csi-lvm", UUID: "u2", Size: 104857600, Attr: "swi-a-s---", SegType: "linear", Stripes: 1,
+					Origin: "pvc-1", OriginSize: 104857600, DataPercent: 0.01, MetadataPercent: -1, SyncPercent: -1, CreationTime: created},
+			},
+		},
+		{
+			name: "one row per segment",
+			exec: reportExecutor{stdout: `{"report": [{"lv": [
+				{"lv_name":"pvc-1", "vg_name":"csi-lvm", "lv_uuid":"u1", "lv_size":"104857600", "segtype":"linear", "stripes":"1"},
+				{"lv_name":"pvc-1", "vg_name":"csi-lvm", "lv_uuid":"u1", "lv_size":"104857600", "segtype":"linear", "stripes":"1"},
+				{"lv_name":"pvc-1", "vg_name":"other", "lv_uuid":"u2", "lv_size":"4194304", "segtype":"linear", "stripes":"1"}
+			]}]}`},
+			want: []LogicalVolume{
+				{Name: "pvc-1", VGName: "csi-lvm", UUID: "u1", Size: 104857600, SegType: "linear", Stripes: 1, DataPercent: -1, MetadataPercent: -1, SyncPercent: -1},
+				{Name: "pvc-1", VGName: "other", UUID: "u2", Size: 4194304, SegType: "linear", Stripes: 1, DataPercent: -1, MetadataPercent: -1, SyncPercent: -1},
+			},
+		},
+		{
+			name: "no volumes",
+			exec: reportExecutor{stdout: `{"report": [{"lv": []}]}`},
+		},
+		{
+			name:    "invalid size",
+			exec:    reportExecutor{stdout: `{"report": [{"lv": [{"lv_name":"pvc-1", "lv_size":"100M"}]}]}`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid percent",
+			exec:    reportExecutor{stdout: `{"report": [{"lv": [{"lv_name":"pvc-1", "sync_percent":"n/a"}]}]}`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			exec:    reportExecutor{stdout: `{"report": [{"lv": [{"lv_name":"pvc-1", "lv_time":"yesterday"}]}]}`},
+			wantErr: true,
+		},
+		{
+			name:    "no json",
+			exec:    reportExecutor{stdout: "  pvc-1 csi-lvm -wi-a----- 100.00m"},
+			wantErr: true,
+		},
+		{
+			name:    "command fails",
+			exec:    reportExecutor{stderr: `Volume group "csi-lvm" not found`, err: errors.New("exit status 5")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reportClient(tt.exec).ListLVs(context.Background(), "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPVs(t *testing.T) {
+	tests := []struct {
+		name    string
+		exec    reportExecutor
+		want    []PhysicalVolume
+		wantErr bool
+	}{
+		{
+			name: "all fields",
+			exec: reportExecutor{stdout: `{"report": [{"pv": [
+				{"pv_name":"/dev/sdb", "vg_name":"csi-lvm", "pv_uuid":"u1", "pv_size":"1069547520", "pv_free":"964689920", "pv_attr":"a--", "pv_tags":"",
+				 "pv_pe_count":"255", "pv_pe_alloc_count":"25"},
+				{"pv_name":"/dev/sdc", "vg_name":"csi-lvm", "pv_uuid":"u2", "pv_size":"2143289344", "pv_free":"2143289344", "pv_attr":"a--", "pv_tags":"hdd",
+				 "pv_pe_count":"511", "pv_pe_alloc_count":"0"}
+			]}]}`},
+			want: []PhysicalVolume{
+				{Name: "/dev/sdb", VGName: "csi-lvm", UUID: "u1", Size: 1069547520, Free: 964689920, Attr: "a--", ExtentCount: 255, AllocatedExtentCount: 25},
+				{Name: "/dev/sdc", VGName: "csi-lvm", UUID: "u2", Size: 2143289344, Free: 2143289344, Attr: "a--", Tags: []string{"hdd"}, ExtentCount: 511},
+			},
+		},
+		{
+			name:    "invalid extent count",
+			exec:    reportExecutor{stdout: `{"report": [{"pv": [{"pv_name":"/dev/sdb", "pv_pe_count":"-"}]}]}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reportClient(tt.exec).ListPVs(context.Background(), "csi-lvm")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListVGs(t *testing.T) {
+	tests := []struct {
+		name    string
+		exec    reportExecutor
+		want    []VolumeGroup
+		wantErr bool
+	}{
+		{
+			name: "all fields",
+			exec: reportExecutor{stdout: `{"report": [{"vg": [
+				{"vg_name":"csi-lvm", "vg_uuid":"u1", "vg_size":"3212836864", "vg_free":"3107979264", "vg_attr":"wz--n-", "vg_tags":"vg.metal-stack.io/csi-lvm-driver",
+				 "vg_extent_size":"4194304", "vg_extent_count":"766", "vg_free_count":"741", "pv_count":"2", "lv_count":"1"}
+			]}]}`},
+			want: []VolumeGroup{{
+				Name: "csi-lvm", UUID: "u1", Size: 3212836864, Free: 3107979264, Attr: "wz--n-", Tags: []string{"vg.metal-stack.io/csi-lvm-driver"},
+				ExtentSize: 4194304, ExtentCount: 766, FreeExtentCount: 741, PVCount: 2, LVCount: 1,
+			}},
+		},
+		{
+			name:    "invalid size",
+			exec:    reportExecutor{stdout: `{"report": [{"vg": [{"vg_name":"csi-lvm", "vg_size":"3.00g"}]}]}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reportClient(tt.exec).ListVGs(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtentsFor(t *testing.T) {
+	const extent = 4 * 1024 * 1024
+
+	tests := []struct {
+		name string
+		lv   LogicalVolume
+		size int64
+		want int64
+	}{
+		{name: "linear", lv: LogicalVolume{SegType: "linear", Stripes: 1}, size: 100 * 1024 * 1024, want: 25},
+		{name: "linear rounded up", lv: LogicalVolume{SegType: "linear", Stripes: 1}, size: 100*1024*1024 + 1, want: 26},
+		{name: "striped", lv: LogicalVolume{SegType: "striped", Stripes: 3}, size: 100 * 1024 * 1024, want: 27},
+		{name: "raid1", lv: LogicalVolume{SegType: "raid1", Stripes: 2}, size: 100 * 1024 * 1024, want: 50},
+		{name: "raid5", lv: LogicalVolume{SegType: "raid5", Stripes: 3}, size: 100 * 1024 * 1024, want: 39},
+		{name: "raid10", lv: LogicalVolume{SegType: "raid10", Stripes: 4}, size: 100 * 1024 * 1024, want: 52},
+		{name: "raid1 with integrity", lv: LogicalVolume{SegType: "raid1", Stripes: 2, IntegrityMode: "journal"}, size: 100 * 1024 * 1024, want: 52},
+		{name: "thin", lv: LogicalVolume{SegType: "thin", Stripes: 1}, size: 100 * 1024 * 1024, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lv.ExtentsFor(tt.size, extent); got != tt.want {
+				t.Errorf("got %d extents, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanExtend(t *testing.T) {
+	const extent = 4 * 1024 * 1024
+
+	tests := []struct {
+		name string
+		lv   LogicalVolume
+		size int64
+		free []int64
+		want bool
+	}{
+		{name: "linear", lv: LogicalVolume{SegType: "linear", Stripes: 1, Size: 25 * extent}, size: 50 * extent, free: []int64{25}, want: true},
+		{name: "linear over several physical volumes", lv: LogicalVolume{SegType: "linear", Stripes: 1, Size: 25 * extent}, size: 50 * extent, free: []int64{10, 15}, want: true},
+		{name: "linear without enough free extents", lv: LogicalVolume{SegType: "linear", Stripes: 1, Size: 25 * extent}, size: 50 * extent, free: []int64{10, 14}},
+		{name: "already large enough", lv: LogicalVolume{SegType: "raid1", Stripes: 2, Size: 25 * extent}, size: 25 * extent, want: true},
+		{name: "raid1", lv: LogicalVolume{SegType: "raid1", Stripes: 2, Size: 25 * extent}, size: 50 * extent, free: []int64{25, 25}, want: true},
+		// the free extents would suffice for both images, but not on different physical volumes
+		{name: "raid1 with free extents on one physical volume", lv: LogicalVolume{SegType: "raid1", Stripes: 2, Size: 25 * extent}, size: 50 * extent, free: []int64{200, 24}},
+		{name: "raid1 on three physical volumes", lv: LogicalVolume{SegType: "raid1", Stripes: 2, Size: 25 * extent}, size: 50 * extent, free: []int64{30, 15, 10}, want: true},
+		{name: "striped", lv: LogicalVolume{SegType: "striped", Stripes: 3, Size: 27 * extent}, size: 54 * extent, free: []int64{9, 9, 9}, want: true},
+		{name: "striped without enough physical volumes", lv: LogicalVolume{SegType: "striped", Stripes: 3, Size: 27 * extent}, size: 54 * extent, free: []int64{100, 100}},
+		{name: "raid5", lv: LogicalVolume{SegType: "raid5_ls", Stripes: 3, Size: 26 * extent}, size: 52 * extent, free: []int64{13, 13, 12}},
+		{name: "raid1 with integrity", lv: LogicalVolume{SegType: "raid1", Stripes: 2, IntegrityMode: "journal", Size: 128 * extent}, size: 256 * extent, free: []int64{129, 129}, want: true},
+		{name: "raid1 without extents for integrity", lv: LogicalVolume{SegType: "raid1", Stripes: 2, IntegrityMode: "journal", Size: 128 * extent}, size: 256 * extent, free: []int64{128, 128}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lv.CanExtend(tt.size, tt.free, extent); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitsInto(t *testing.T) {
+	const extent = 4 * 1024 * 1024
+
+	tests := []struct {
+		name    string
+		lv      LogicalVolume
+		size    int64
+		extents []int64
+		want    bool
+	}{
+		{name: "linear", lv: LogicalVolume{SegType: "linear", Stripes: 1}, size: 100 * extent, extents: []int64{50, 50}, want: true},
+		{name: "linear larger than the volume group", lv: LogicalVolume{SegType: "linear", Stripes: 1}, size: 101 * extent, extents: []int64{50, 50}},
+		{name: "raid1 with metadata", lv: LogicalVolume{SegType: "raid1", Stripes: 2}, size: 49 * extent, extents: []int64{50, 50}, want: true},
+		{name: "raid1 without extent for metadata", lv: LogicalVolume{SegType: "raid1", Stripes: 2}, size: 50 * extent, extents: []int64{50, 50}},
+		// the smaller physical volume limits both images
+		{name: "raid1 on uneven physical volumes", lv: LogicalVolume{SegType: "raid1", Stripes: 2}, size: 60 * extent, extents: []int64{255, 50}},
+		{name: "striped", lv: LogicalVolume{SegType: "striped", Stripes: 2}, size: 100 * extent, extents: []int64{50, 50}, want: true},
+		{name: "raid10", lv: LogicalVolume{SegType: "raid10", Stripes: 4}, size: 98 * extent, extents: []int64{50, 50, 50, 50}, want: true},
+		{name: "raid10 on too few physical volumes", lv: LogicalVolume{SegType: "raid10", Stripes: 4}, size: 4 * extent, extents: []int64{50, 50, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lv.FitsInto(tt.size, tt.extents, extent); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package lvm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ThinPoolName is the name of the thin pool which is created in the volume group for thin volumes
+const ThinPoolName = "csi-lvm-thinpool"
+
+// ThinPool describes the allocation of a thin pool
+type ThinPool struct {
+	Name      string
+	SizeBytes int64
+	// VirtualSizeBytes is the sum of the sizes of all thin volumes in the pool
+	VirtualSizeBytes int64
+	// DataPercent and MetadataPercent are the allocated percentages of the data and metadata of the pool
+	DataPercent     float64
+	MetadataPercent float64
+}
+
+// capacity returns the size which is left for new thin volumes. The sum of the sizes of all thin volumes must not exceed
+// the size of the pool multiplied by overcommitRatio, and only the data which is not allocated yet can be overcommitted.
+// A pool whose metadata is full can not take any more data either.
+func (tp *ThinPool) capacity(overcommitRatio float64) int64 {
+	allocated := max(tp.DataPercent, tp.MetadataPercent, 0)
+	free := float64(tp.SizeBytes) * (100 - allocated) / 100
+
+	capacity := min(int64(float64(tp.SizeBytes)*overcommitRatio)-tp.VirtualSizeBytes, int64(free*overcommitRatio))
+	return max(capacity, 0)
+}
+
+// GetThinPool returns the thin pool with the given name, nil is returned if it does not exist
+func (c *Client) GetThinPool(ctx context.Context, vg string, pool string) (*ThinPool, error) {
+	lvs, err := c.ListLVs(ctx, vg)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		found bool
+		tp    = &ThinPool{Name: pool}
+	)
+	for _, lv := range lvs {
+		switch {
+		case lv.Name == pool && lv.SegType == "thin-pool":
+			found = true
+			tp.SizeBytes = lv.Size
+			tp.DataPercent = lv.DataPercent
+			tp.MetadataPercent = lv.MetadataPercent
+		case lv.PoolLV == pool:
+			tp.VirtualSizeBytes += lv.Size
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return tp, nil
+}
+
+// CreateThinPool creates a thin pool which takes sizePercent of the free space of the volume group
+func (c *Client) CreateThinPool(ctx context.Context, vg string, pool string, sizePercent int) (string, error) {
+	if sizePercent <= 0 || sizePercent > 100 {
+		return "", newError(ErrInvalidArgument, "thin pool size must be between 1 and 100 percent, got %d", sizePercent)
+	}
+
+	args := []string{"-v", "--yes", "--type", "thin-pool", "-n", pool, "-l", fmt.Sprintf("%d%%FREE", sizePercent), "--addtag", "lv.metal-stack.io/csi-lvm-driver", vg}
+	c.log.Debug("lvcreate", "args", args)
+	return c.run(ctx, "lvcreate", args...)
+}
+
+// CreateThinLV creates a thin volume in the given thin pool. The thin pool is created if it does not exist yet.
+// The volume must fit into the capacity of the pool as ThinCapacity reports it, the check and the creation
+// are serialized per pool, so concurrent requests can not overcommit the pool together.
+func (c *Client) CreateThinLV(ctx context.Context, vg string, pool string, name string, size uint64, poolSizePercent int, overcommitRatio float64, tags ...string) (string, error) {
+	unlock := c.lockThinPool(vg, pool)
+	defer unlock()
+
+	lv, err := c.GetLV(ctx, vg, name)
+	if err != nil {
+		return "", err
+	}
+	if lv != nil {
+		c.log.Debug("logicalvolume already exists", "name", name)
+		return name, nil
+	}
+
+	if size == 0 {
+		return "", newError(ErrInvalidArgument, "size must be greater than 0")
+	}
+
+	tp, err := c.GetThinPool(ctx, vg, pool)
+	if err != nil {
+		return "", err
+	}
+	if tp == nil {
+		c.log.Info("thin pool does not exist yet - creating...", "vg", vg, "pool", pool)
+		out, err := c.CreateThinPool(ctx, vg, pool, poolSizePercent)
+		if err != nil {
+			return out, fmt.Errorf("unable to create thin pool %s: %w", pool, err)
+		}
+
+		tp, err = c.GetThinPool(ctx, vg, pool)
+		if err != nil {
+			return "", err
+		}
+		if tp == nil {
+			return "", fmt.Errorf("thin pool %s not found after creation", pool)
+		}
+	}
+
+	if capacity := tp.capacity(overcommitRatio); int64(size) > capacity { //nolint:gosec
+		return "", newError(ErrInsufficientSpace, "thin pool %s has only %d bytes left for %d bytes with overcommit ratio %.2f, %.2f%% of its data and %.2f%% of its metadata are allocated",
+			pool, capacity, size, overcommitRatio, tp.DataPercent, tp.MetadataPercent)
+	}
+
+	args := []string{"-v", "--yes", "-n", name, "-V", fmt.Sprintf("%db", size), "--thinpool", pool, "--addtag", "lv.metal-stack.io/csi-lvm-driver"}
+	for _, tag := range tags {
+		args = append(args, "--addtag", tag)
+	}
+	args = append(args, vg)
+	c.log.Debug("lvcreate", "args", args)
+	return c.run(ctx, "lvcreate", args...)
+}
+
+// ThinCapacity returns the capacity which is left for new thin volumes in the given volume group.
+// If the thin pool does not exist yet, the capacity of a thin pool that would be created is returned.
+func (c *Client) ThinCapacity(ctx context.Context, vg string, pool string, poolSizePercent int, overcommitRatio float64) (int64, error) {
+	tp, err := c.GetThinPool(ctx, vg, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	if tp == nil {
+		v, err := c.GetVG(ctx, vg)
+		if err != nil {
+			return 0, err
+		}
+		if v == nil {
+			return 0, newError(ErrNotFound, "volume group %s does not exist", vg)
+		}
+		return int64(float64(v.Free) * float64(poolSizePercent) / 100 * overcommitRatio), nil
+	}
+
+	return tp.capacity(overcommitRatio), nil
+}
+
+// lockThinPool serializes the creation of thin volumes in the thin pool and returns the function to release it
+func (c *Client) lockThinPool(vg string, pool string) func() {
+	mu, _ := c.thinPools.LoadOrStore(vg+"/"+pool, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
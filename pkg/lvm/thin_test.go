@@ -0,0 +1,281 @@
+package lvm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+)
+
+const (
+	testVG = "csi-lvm"
+
+	mib = int64(1024 * 1024)
+	gib = 1024 * mib
+)
+
+// newTestClient returns a client of the fake with the volume group csi-lvm, which has a physical volume for every entry of pvSizes
+func newTestClient(t *testing.T, pvSizes ...int64) (*lvm.Client, *fake.Executor) {
+	t.Helper()
+
+	f := fake.New()
+	err := f.AddVG(testVG, pvSizes...)
+	if err != nil {
+		t.Fatalf("unable to create vg: %v", err)
+	}
+
+	return lvm.New(slog.New(slog.DiscardHandler), f, time.Second), f
+}
+
+// createThinPool creates the thin pool with 90% of the free space and returns it
+func createThinPool(t *testing.T, c *lvm.Client) *lvm.ThinPool {
+	t.Helper()
+
+	_, err := c.CreateThinPool(context.Background(), testVG, lvm.ThinPoolName, 90)
+	if err != nil {
+		t.Fatalf("unable to create thin pool: %v", err)
+	}
+	tp, err := c.GetThinPool(context.Background(), testVG, lvm.ThinPoolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp == nil {
+		t.Fatal("thin pool not found after it was created")
+	}
+
+	return tp
+}
+
+func TestGetThinPool(t *testing.T) {
+	c, _ := newTestClient(t, gib)
+
+	tp, err := c.GetThinPool(context.Background(), testVG, lvm.ThinPoolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != nil {
+		t.Fatalf("got thin pool %v before it was created", tp)
+	}
+
+	tp = createThinPool(t, c)
+	if want := 230 * int64(fake.DefaultExtentSize); tp.SizeBytes != want {
+		t.Errorf("got pool size %d, want 90%% of the free extents with %d bytes", tp.SizeBytes, want)
+	}
+	if tp.VirtualSizeBytes != 0 || tp.DataPercent != 0 || tp.MetadataPercent != 0 {
+		t.Errorf("got virtual size %d, %.2f%% data and %.2f%% metadata of an empty pool", tp.VirtualSizeBytes, tp.DataPercent, tp.MetadataPercent)
+	}
+
+	for _, name := range []string{"pvc-1", "pvc-2"} {
+		_, err = c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, name, uint64(gib), 90, 10) //nolint:gosec
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// thick volumes do not count
+	_, err = c.CreateLV(context.Background(), testVG, "pvc-3", uint64(10*mib), lvm.Layout{Type: "linear"}) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err = c.GetThinPool(context.Background(), testVG, lvm.ThinPoolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * gib; tp.VirtualSizeBytes != want {
+		t.Errorf("got virtual size %d, want %d", tp.VirtualSizeBytes, want)
+	}
+}
+
+func TestCreateThinPool(t *testing.T) {
+	tests := []struct {
+		name        string
+		sizePercent int
+		wantErr     error
+	}{
+		{name: "part of the free space", sizePercent: 50},
+		{name: "all free space", sizePercent: 100},
+		{name: "no space", sizePercent: 0, wantErr: lvm.ErrInvalidArgument},
+		{name: "more than the free space", sizePercent: 101, wantErr: lvm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(t, gib)
+
+			_, err := c.CreateThinPool(context.Background(), testVG, lvm.ThinPoolName, tt.sizePercent)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			tp, err := c.GetThinPool(context.Background(), testVG, lvm.ThinPoolName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := 256 * int64(tt.sizePercent) / 100 * int64(fake.DefaultExtentSize); tp.SizeBytes != want {
+				t.Errorf("got pool size %d, want %d", tp.SizeBytes, want)
+			}
+		})
+	}
+}
+
+func TestCreateThinLV(t *testing.T) {
+	// the pool takes 230 extents of 4MiB, 920MiB with an overcommit ratio of 2 are 1840MiB
+	tests := []struct {
+		name            string
+		existing        []int64
+		dataPercent     float64
+		metadataPercent float64
+		size            int64
+		wantErr         error
+	}{
+		{name: "empty pool", size: 100 * mib},
+		{name: "overcommitted", size: 1840 * mib},
+		{name: "exceeds overcommit ratio", size: 1844 * mib, wantErr: lvm.ErrInsufficientSpace},
+		{name: "fits next to other volumes", existing: []int64{1000 * mib}, size: 840 * mib},
+		{name: "exceeds overcommit ratio with other volumes", existing: []int64{1000 * mib}, size: 844 * mib, wantErr: lvm.ErrInsufficientSpace},
+		// half of the data is allocated, so only 460MiB can be overcommitted
+		{name: "fits into the free data", existing: []int64{100 * mib}, dataPercent: 50, size: 920 * mib},
+		{name: "exceeds the free data", existing: []int64{100 * mib}, dataPercent: 50, size: 924 * mib, wantErr: lvm.ErrInsufficientSpace},
+		{name: "full data", existing: []int64{100 * mib}, dataPercent: 100, size: 4 * mib, wantErr: lvm.ErrInsufficientSpace},
+		{name: "full metadata", existing: []int64{100 * mib}, metadataPercent: 100, size: 4 * mib, wantErr: lvm.ErrInsufficientSpace},
+		{name: "empty volume", size: 0, wantErr: lvm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, f := newTestClient(t, gib)
+
+			for i, size := range tt.existing {
+				_, err := c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, fmt.Sprintf("pvc-existing-%d", i), uint64(size), 90, 2) //nolint:gosec
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.dataPercent != 0 || tt.metadataPercent != 0 {
+				err := f.SetThinPoolUsage(testVG, lvm.ThinPoolName, tt.dataPercent, tt.metadataPercent)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the pool is created with the first volume
+			_, err := c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, "pvc-1", uint64(tt.size), 90, 2) //nolint:gosec
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			lv, err := c.GetLV(context.Background(), testVG, "pvc-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != nil {
+				if lv != nil {
+					t.Errorf("volume was created")
+				}
+				return
+			}
+			if lv == nil || !lv.IsThin() || lv.PoolLV != lvm.ThinPoolName {
+				t.Fatalf("got volume %v, want a thin volume of the pool", lv)
+			}
+			if lv.Size != tt.size {
+				t.Errorf("got size %d, want %d", lv.Size, tt.size)
+			}
+
+			// a retried request returns the existing volume, even if it does not fit anymore
+			_, err = c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, "pvc-1", uint64(tt.size), 90, 2) //nolint:gosec
+			if err != nil {
+				t.Errorf("retried request failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateThinLVConcurrently(t *testing.T) {
+	c, f := newTestClient(t, gib)
+	tp := createThinPool(t, c)
+
+	// all requests check the capacity before the first volume is created
+	f.Delay("lvcreate", 10*time.Millisecond)
+
+	// exactly five volumes fit into the pool without overcommitting it
+	size := tp.SizeBytes / 5
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := range 10 {
+		wg.Go(func() {
+			_, err := c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, fmt.Sprintf("pvc-%d", i), uint64(size), 90, 1) //nolint:gosec
+			if err != nil && !errors.Is(err, lvm.ErrInsufficientSpace) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	if created != 5 {
+		t.Errorf("created %d volumes, want 5", created)
+	}
+}
+
+func TestThinCapacity(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        int64
+		dataPercent     float64
+		metadataPercent float64
+		want            int64
+	}{
+		// 90% of the free 1GiB with an overcommit ratio of 2
+		{name: "without pool", existing: -1, want: 1932735283},
+		{name: "empty pool", want: 1840 * mib},
+		{name: "pool with volumes", existing: 1000 * mib, want: 840 * mib},
+		{name: "allocated data", existing: 1000 * mib, dataPercent: 75, want: 460 * mib},
+		{name: "allocated metadata", existing: 1000 * mib, dataPercent: 10, metadataPercent: 75, want: 460 * mib},
+		{name: "full pool", existing: 1000 * mib, dataPercent: 100},
+		{name: "overcommitted pool", existing: 1840 * mib, dataPercent: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, f := newTestClient(t, gib)
+
+			if tt.existing >= 0 {
+				createThinPool(t, c)
+			}
+			if tt.existing > 0 {
+				_, err := c.CreateThinLV(context.Background(), testVG, lvm.ThinPoolName, "pvc-existing", uint64(tt.existing), 90, 10) //nolint:gosec
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.existing >= 0 {
+				err := f.SetThinPoolUsage(testVG, lvm.ThinPoolName, tt.dataPercent, tt.metadataPercent)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := c.ThinCapacity(context.Background(), testVG, lvm.ThinPoolName, 90, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got capacity %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+)
+
+// volumeConditions keeps the last known condition of the logical volumes on this node,
+// they are lost when the plugin restarts
+type volumeConditions struct {
+	mu         sync.Mutex
+	conditions map[string]*csi.VolumeCondition
+}
+
+func newVolumeConditions() *volumeConditions {
+	return &volumeConditions{
+		conditions: map[string]*csi.VolumeCondition{},
+	}
+}
+
+func (c *volumeConditions) set(name string, abnormal bool, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conditions[name] = &csi.VolumeCondition{Abnormal: abnormal, Message: message}
+}
+
+// get returns the condition of the logical volume, nil is returned if nothing is known about it
+func (c *volumeConditions) get(name string) *csi.VolumeCondition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conditions[name]
+}
+
+func (c *volumeConditions) delete(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.conditions, name)
+}
+
+// raidCondition returns the condition of a raid volume which is unhealthy or not in sync yet, nil is returned otherwise
+func raidCondition(lv *lvm.LogicalVolume) *csi.VolumeCondition {
+	switch {
+	case lv.HealthStatus != "":
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("raid health status is %s", lv.HealthStatus)}
+	case lv.IsSyncing():
+		return &csi.VolumeCondition{Message: fmt.Sprintf("raid is synchronizing, %.2f%% in sync", lv.SyncPercent)}
+	default:
+		return nil
+	}
+}
+
+// volumeCondition combines the condition of the last filesystem check with the current state of a raid volume,
+// an abnormal condition is reported in favor of a normal one
+func (d *Driver) volumeCondition(ctx context.Context, id string) *csi.VolumeCondition {
+	condition := d.volumeConditions.get(lockName(id))
+	if condition.GetAbnormal() {
+		return condition
+	}
+
+	vid, found, err := d.lookupVolume(ctx, id)
+	if err != nil || !found {
+		return condition
+	}
+	lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+	if err != nil || lv == nil {
+		d.log.Debug("unable to get raid state of volume", "volume-id", id, "error", err)
+		return condition
+	}
+
+	raid := raidCondition(lv)
+	if raid == nil {
+		return condition
+	}
+	d.log.Info("raid volume condition", "volume-id", id, "abnormal", raid.Abnormal, "message", raid.Message)
+	if condition == nil || raid.Abnormal {
+		return raid
+	}
+
+	return &csi.VolumeCondition{Message: condition.GetMessage() + ", " + raid.GetMessage()}
+}
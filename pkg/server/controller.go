@@ -3,12 +3,15 @@ package server
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -22,11 +25,18 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities missing in request")
 	}
 
+	unlock, err := d.lockVolumes("CreateVolume", req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	var (
 		// Keep a record of the requested access types.
 		accessTypeMount, accessTypeBlock bool
 
-		integrity = false
+		// resizeBlockFilesystem lets the filesystem inside of a raw block volume grow with it
+		resizeBlockFilesystem = false
 	)
 
 	for _, cap := range caps {
@@ -42,40 +52,196 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, "cannot have both block and mount access type")
 	}
 
-	lvmType := req.GetParameters()["type"]
-	switch lvmType {
-	case "linear", "mirror", "striped":
-		// these are supported lvm types
-	default:
-		return nil, status.Errorf(codes.Internal, "lvmType is incorrect: %s", lvmType)
+	if _, _, err := rootDirectoryMode(req.GetParameters()); err != nil {
+		return nil, err
+	}
+	if _, err := fsckPolicy(req.GetParameters()); err != nil {
+		return nil, err
+	}
+
+	// the volume is formatted on the node, unsupported filesystems and invalid mkfs parameters are rejected before it is created
+	for _, cap := range caps {
+		if cap.GetMount() == nil {
+			continue
+		}
+		if _, err := mkfsArgs(cap.GetMount().GetFsType(), req.GetParameters()); err != nil {
+			return nil, err
+		}
 	}
 
-	if value, ok := req.GetParameters()["integrity"]; ok {
+	layout, err := volumeLayout(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := req.GetParameters()["resizeBlockFilesystem"]; ok {
 		var err error
-		integrity, err = strconv.ParseBool(value)
+		resizeBlockFilesystem, err = strconv.ParseBool(value)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse integrity parameter to bool: %w", err)
+			return nil, status.Errorf(codes.InvalidArgument, "unable to parse resizeBlockFilesystem parameter to bool: %v", err)
 		}
 	}
 
-	d.log.Info("creating volume", "name", req.GetName())
+	dc, err := d.deviceClass(req.GetParameters()[deviceClassParameter])
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkVolumeID(dc.VGName, req.GetName()); err != nil {
+		return nil, err
+	}
 
 	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+	limitBytes := req.GetCapacityRange().GetLimitBytes()
+	if requiredBytes < 0 || limitBytes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "required and limit bytes must not be negative")
+	}
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return nil, status.Errorf(codes.OutOfRange, "required bytes %d exceed limit of %d bytes", requiredBytes, limitBytes)
+	}
 
-	_, err := lvm.CreateLV(d.log, d.vgName, req.GetName(), uint64(requiredBytes), lvmType, integrity)
+	var (
+		sourceSnapshot string
+		sourceVolume   string
+		sourceVG       string
+		sourceSize     int64
+		// contentSource identifies the source in the tags of the volume, so a retry can not return a volume with other content
+		contentSource string
+	)
+	// the source is only locked until the volume and the temporary snapshot of a clone exist, the copy runs without the lock
+	unlockSource, err := d.lockVolumes("CreateVolume", req.GetVolumeContentSource().GetSnapshot().GetSnapshotId(), req.GetVolumeContentSource().GetVolume().GetVolumeId())
 	if err != nil {
-		return nil, fmt.Errorf("unable to create lv %s: %w", req.GetName(), err)
+		return nil, err
+	}
+	defer unlockSource()
+
+	switch source := req.GetVolumeContentSource().GetType().(type) {
+	case nil:
+	case *csi.VolumeContentSource_Snapshot:
+		snapshot, err := d.findSnapshot(ctx, source.Snapshot.GetSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+		if snapshot.IsInvalidSnapshot() {
+			return nil, status.Errorf(codes.FailedPrecondition, "snapshot %s is invalid and can not be restored", snapshot.Name)
+		}
+
+		sourceSnapshot = snapshot.Name
+		sourceVG = snapshot.VGName
+		sourceSize = snapshot.OriginSize
+		contentSource = snapshotContentSource(sourceVG, sourceSnapshot)
+	case *csi.VolumeContentSource_Volume:
+		vid, err := d.existingVolume(ctx, source.Volume.GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+
+		lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+		if err != nil {
+			return nil, statusError(err, "unable to determine size of source volume %s", vid.LVName)
+		}
+		if lv == nil {
+			return nil, status.Errorf(codes.NotFound, "source volume %s not found", source.Volume.GetVolumeId())
+		}
+
+		sourceVolume = lv.Name
+		sourceVG = lv.VGName
+		sourceSize = lv.Size
+		contentSource = fmt.Sprintf("volume:%s/%s", sourceVG, sourceVolume)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported volume content source %T", source)
+	}
+
+	if requiredBytes < sourceSize {
+		requiredBytes = sourceSize
+	}
+	if limitBytes > 0 && limitBytes < sourceSize {
+		return nil, status.Errorf(codes.OutOfRange, "volume content source of size %d does not fit into limit of %d bytes", sourceSize, limitBytes)
 	}
 
-	d.log.Info("successfully created lv", "name", req.GetName())
+	d.log.Info("creating volume", "name", req.GetName(), "device-class", dc.Name)
+
+	vg, existed, err := d.lookupVG(ctx, req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if existed && vg != dc.VGName {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists in vg %s", req.GetName(), vg)
+	}
+
+	resolved, size, err := d.volumeSize(ctx, dc.VGName, layout, requiredBytes, limitBytes)
+	if err != nil {
+		return nil, err
+	}
+	if existed {
+		if err := d.checkExistingVolume(ctx, dc.VGName, req.GetName(), resolved, size, limitBytes, contentSource); err != nil {
+			return nil, err
+		}
+	}
+
+	// the copy is only complete once the pending tag is removed, a volume which still has it after a crash is copied again
+	var tags []string
+	if contentSource != "" {
+		tags = []string{lvm.CopyPendingTag, lvm.ContentSourceTag(contentSource)}
+	}
+	output, err := d.createLV(ctx, dc.VGName, req.GetName(), uint64(size), layout, tags...) //nolint:gosec
+	if err != nil {
+		return nil, statusError(err, "unable to create lv %s, output:%s", req.GetName(), output)
+	}
+
+	lv, err := d.lvm.GetLV(ctx, dc.VGName, req.GetName())
+	if err != nil {
+		return nil, statusError(err, "unable to lookup lv %s", req.GetName())
+	}
+	if lv == nil {
+		return nil, status.Errorf(codes.Internal, "lv %s disappeared after it was created", req.GetName())
+	}
+
+	if lv.HasTag(lvm.CopyPendingTag) {
+		if sourceVolume != "" {
+			d.log.Info("cloning volume", "name", req.GetName(), "source-volume", sourceVolume)
+			output, err = d.lvm.CreateCloneSnapshot(ctx, sourceVG, sourceVolume, req.GetName())
+		} else {
+			d.log.Info("restoring volume from snapshot", "name", req.GetName(), "snapshot", sourceSnapshot)
+		}
+		// a volume with a snapshot can not be deleted and a snapshot can not be deleted while a pending volume is restored from it
+		unlockSource()
+		if err == nil {
+			if sourceVolume != "" {
+				output, err = d.lvm.CloneLV(ctx, sourceVG, dc.VGName, req.GetName())
+			} else {
+				output, err = d.lvm.CopyLV(ctx, sourceVG, sourceSnapshot, dc.VGName, req.GetName())
+			}
+		}
+		if err != nil {
+			// remove the partially copied volume, so a retry starts from scratch, even if the request was canceled
+			if out, rerr := d.lvm.RemoveLVS(context.WithoutCancel(ctx), dc.VGName, req.GetName()); rerr != nil {
+				d.log.Error("unable to remove partially copied lv", "name", req.GetName(), "error", rerr, "output", out)
+			}
+			return nil, statusError(err, "unable to copy content into lv %s, output:%s", req.GetName(), output)
+		}
+
+		output, err = d.lvm.RemoveLVTag(ctx, dc.VGName, req.GetName(), lvm.CopyPendingTag)
+		if err != nil {
+			return nil, statusError(err, "unable to mark the copy into lv %s as complete, output:%s", req.GetName(), output)
+		}
+	}
+
+	if resizeBlockFilesystem && accessTypeBlock {
+		output, err = d.lvm.AddLVTag(ctx, dc.VGName, req.GetName(), lvm.ResizeFilesystemTag)
+		if err != nil {
+			return nil, statusError(err, "unable to tag lv %s, output:%s", req.GetName(), output)
+		}
+	}
+
+	d.log.Info("successfully created lv", "name", req.GetName(), "size", lv.Size)
 
 	volumeContext := req.GetParameters()
 	volumeContext["RequiredBytes"] = strconv.FormatInt(requiredBytes, 10)
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      req.GetName(),
-			CapacityBytes: requiredBytes,
+			VolumeId:      d.newVolumeID(dc.VGName, req.GetName()),
+			CapacityBytes: lv.Size,
 			VolumeContext: volumeContext,
 			ContentSource: req.GetVolumeContentSource(),
 			AccessibleTopology: []*csi.Topology{{
@@ -85,22 +251,95 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}, nil
 }
 
+// volumeSize returns the layout resolved for the volume group and the size lvm allocates for a volume of it with at least
+// required bytes, at least one extent is allocated for empty volumes. OutOfRange is returned if the rounded size exceeds limit.
+func (d *Driver) volumeSize(ctx context.Context, vgName string, layout lvm.Layout, required int64, limit int64) (lvm.Layout, int64, error) {
+	vg, err := d.lvm.GetVG(ctx, vgName)
+	if err != nil {
+		return layout, 0, statusError(err, "unable to get vg %s", vgName)
+	}
+	if vg == nil {
+		return layout, 0, status.Errorf(codes.NotFound, "vg %s does not exist", vgName)
+	}
+
+	if layout.Type != thinType {
+		layout, err = layout.Resolve(vg.PVCount)
+		if err != nil {
+			return layout, 0, statusError(err, "unable to resolve layout %s for vg %s", layout.Type, vgName)
+		}
+	}
+
+	size := layout.RoundSize(required, vg.ExtentSize)
+	if limit > 0 && size > limit {
+		return layout, 0, status.Errorf(codes.OutOfRange, "required bytes %d are rounded up to %d bytes by the extents of vg %s, which exceeds the limit of %d bytes", required, size, vgName, limit)
+	}
+
+	return layout, size, nil
+}
+
+// checkExistingVolume returns AlreadyExists if the logical volume of a retried request does not have the resolved layout,
+// its size is not between size and limit or it was created from another content source, a volume which was created for
+// another request is never returned.
+func (d *Driver) checkExistingVolume(ctx context.Context, vgName string, name string, layout lvm.Layout, size int64, limit int64, contentSource string) error {
+	lv, err := d.lvm.GetLV(ctx, vgName, name)
+	if err != nil {
+		return statusError(err, "unable to lookup volume %s", name)
+	}
+	if lv == nil {
+		return nil
+	}
+
+	if !layout.Matches(lv) {
+		return status.Errorf(codes.AlreadyExists, "volume %s already exists with type %s, %d images and integrity %t, which does not match the requested type %s with %d images and integrity %t",
+			name, lv.SegType, lv.Stripes, lv.IntegrityMode != "", layout.Type, layout.Images(), layout.Integrity)
+	}
+	if lv.Size < size || limit > 0 && lv.Size > limit {
+		return status.Errorf(codes.AlreadyExists, "volume %s already exists with %d bytes, which does not match the requested %d bytes with a limit of %d bytes", name, lv.Size, size, limit)
+	}
+	if lv.ContentSource() != contentSource {
+		return status.Errorf(codes.AlreadyExists, "volume %s already exists with content source %q, which does not match the requested content source %q", name, lv.ContentSource(), contentSource)
+	}
+
+	return nil
+}
+
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume id missing in request")
 	}
 
-	existsVolume := lvm.LvExists(d.log, d.vgName, req.VolumeId)
+	unlock, err := d.lockVolumes("DeleteVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vid, existsVolume, err := d.lookupVolume(ctx, req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
 	if !existsVolume {
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
+	// lvremove would remove the snapshots with their origin, this includes the temporary snapshot of a clone in progress
+	lvs, err := d.lvm.ListLVs(ctx, vid.VGName)
+	if err != nil {
+		return nil, statusError(err, "unable to list snapshots")
+	}
+	for _, lv := range lvs {
+		if lv.IsSnapshot() && lv.Origin == vid.LVName {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s still has snapshot %s", req.VolumeId, lv.Name)
+		}
+	}
+
 	d.log.Info("trying to delete volume", "volume-id", req.VolumeId)
 
-	_, err := lvm.RemoveLVS(d.log, d.vgName, req.VolumeId)
+	output, err := d.lvm.RemoveLVS(ctx, vid.VGName, vid.LVName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to delete volume with id %s: %w", req.VolumeId, err)
+		return nil, statusError(err, "unable to delete volume with id %s, output:%s", req.VolumeId, output)
 	}
+	d.volumeConditions.delete(vid.LVName)
 
 	d.log.Info("volume successfully deleted", "volume-id", req.VolumeId)
 
@@ -124,10 +363,139 @@ func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Control
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
+// ControllerExpandVolume grows the logical volume, its filesystem is grown by NodeExpandVolume.
+// The resizer runs only once in the cluster, so requests for volumes of other nodes are forwarded to the node plugin of their node.
+// The space in the volume group is checked first, so volumes which can not be expanded are rejected before they are touched.
+// Volumes which are not published are expanded as well.
+func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if err := checkExpandVolumeRequest(req); err != nil {
+		return nil, err
+	}
+
+	vid, err := parseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	local := vid.Node == d.nodeId
+	if vid.Node == "" {
+		_, local, err = d.lookupVolume(ctx, req.GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if local {
+		return d.expandVolume(ctx, req)
+	}
+
+	if vid.Node == "" || !d.peers.enabled() {
+		// NodeExpandVolume extends the logical volume as well once it is published on its node
+		d.log.Info("volume is not on this node, it is expanded by its node", "volume-id", req.GetVolumeId(), "required-bytes", req.GetCapacityRange().GetRequiredBytes())
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         req.GetCapacityRange().GetRequiredBytes(),
+			NodeExpansionRequired: true,
+		}, nil
+	}
+
+	return d.forwardExpansion(ctx, vid.Node, req)
+}
+
+// checkExpandVolumeRequest returns an error if the arguments of the expansion request are invalid or its limit is below the required bytes
+func checkExpandVolumeRequest(req *csi.ControllerExpandVolumeRequest) error {
+	if len(req.GetVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "volume id missing in request")
+	}
+	if req.GetCapacityRange() == nil {
+		return status.Error(codes.InvalidArgument, "capacity range missing in request")
+	}
+	required := req.GetCapacityRange().GetRequiredBytes()
+	limit := req.GetCapacityRange().GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return status.Error(codes.InvalidArgument, "required and limit bytes must not be negative")
+	}
+	if limit > 0 && required > limit {
+		return status.Errorf(codes.OutOfRange, "required bytes %d exceed limit of %d bytes", required, limit)
+	}
+	return nil
+}
+
+// expandVolume grows a logical volume of this node, the request is never forwarded to another node.
+func (d *Driver) expandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	unlock, err := d.lockVolumes("ControllerExpandVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vid, err := d.existingVolume(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	lv, size, err := d.expansionSize(ctx, req.GetVolumeId(), vid, req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if lv.Size < size {
+		output, err := d.lvm.ExtendLV(ctx, vid.VGName, vid.LVName, uint64(size)) //nolint:gosec
+		if err != nil {
+			return nil, statusError(err, "unable to extend lv %s, output:%s", vid.LVName, output)
+		}
+
+		lv, err = d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+		if err != nil {
+			return nil, statusError(err, "unable to lookup volume %s", req.GetVolumeId())
+		}
+		if lv == nil {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", req.GetVolumeId())
+		}
+	}
+
+	// filesystems are grown on the node, raw block volumes only if their filesystem should grow with them
+	nodeExpansionRequired := true
+	if req.GetVolumeCapability().GetBlock() != nil {
+		nodeExpansionRequired = lv.HasTag(lvm.ResizeFilesystemTag)
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         lv.Size,
+		NodeExpansionRequired: nodeExpansionRequired,
+	}, nil
+}
+
 func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
@@ -156,31 +524,298 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 }
 
 func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	lvmType := req.GetParameters()["type"]
-
-	switch lvmType {
-	case "linear", "mirror", "striped":
-		// These are supported lvm types
-	default:
-		return nil, status.Errorf(codes.Internal, "lvmType is incorrect: %s", lvmType)
+	layout, err := volumeLayout(req.GetParameters())
+	if err != nil {
+		return nil, err
 	}
 
-	totalBytes, err := lvm.VgStats(d.log, d.vgName)
+	dc, err := d.deviceClass(req.GetParameters()[deviceClassParameter])
 	if err != nil {
-		return nil, fmt.Errorf("unable to get capacity of vg %s", d.vgName)
+		return nil, err
 	}
 
-	// adjust available capacity for mirrored volumes
-	// as we only offer a single mirror we do not need something more specific for calculation
-	if lvmType == "mirror" {
-		totalBytes = totalBytes / 2
+	availableBytes, maximumBytes, err := d.layoutCapacity(ctx, dc.VGName, layout)
+	if err != nil {
+		return nil, statusError(err, "unable to get capacity of vg %s", dc.VGName)
 	}
 
-	d.log.Debug("available capacity", "bytes", totalBytes, "lvm-type", lvmType)
+	d.log.Debug("available capacity", "bytes", availableBytes, "maximum-volume-size", maximumBytes, "lvm-type", layout.Type, "device-class", dc.Name)
 
 	return &csi.GetCapacityResponse{
-		AvailableCapacity: totalBytes,
-		MaximumVolumeSize: wrapperspb.Int64(totalBytes),
+		AvailableCapacity: availableBytes,
+		MaximumVolumeSize: wrapperspb.Int64(maximumBytes),
 		MinimumVolumeSize: wrapperspb.Int64(0),
 	}, nil
 }
+
+// layoutCapacity returns the size of all volumes of the layout which fit into the free extents of the physical volumes
+// and the size of the largest single one, 0 is returned if the volume group has not enough physical volumes for the layout.
+func (d *Driver) layoutCapacity(ctx context.Context, vgName string, layout lvm.Layout) (available int64, maximum int64, err error) {
+	if layout.Type == thinType {
+		capacity, err := d.lvm.ThinCapacity(ctx, vgName, lvm.ThinPoolName, d.thinPoolSizePercent, d.thinOvercommitRatio)
+		return capacity, capacity, err
+	}
+
+	vg, err := d.lvm.GetVG(ctx, vgName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if vg == nil {
+		return 0, 0, fmt.Errorf("vg %s does not exist", vgName)
+	}
+
+	resolved, err := layout.Resolve(vg.PVCount)
+	if err != nil {
+		d.log.Debug("layout does not fit into volume group", "vg", vgName, "lvm-type", layout.Type, "error", err)
+		return 0, 0, nil
+	}
+
+	pvs, err := d.lvm.ListPVs(ctx, vgName)
+	if err != nil {
+		return 0, 0, err
+	}
+	free := make([]int64, 0, len(pvs))
+	for _, pv := range pvs {
+		free = append(free, pv.ExtentCount-pv.AllocatedExtentCount)
+	}
+
+	available, maximum = resolved.Capacity(free, vg.ExtentSize)
+	return available, maximum, nil
+}
+
+func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name missing in request")
+	}
+	if len(req.GetSourceVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "source volume id missing in request")
+	}
+
+	name := snapshotLVName(req.GetName())
+
+	unlock, err := d.lockVolumes("CreateSnapshot", name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// the source is only locked until the snapshot exists
+	unlockSource, err := d.lockVolumes("CreateSnapshot", req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlockSource()
+
+	source, err := d.existingVolume(ctx, req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkVolumeID(source.VGName, name); err != nil {
+		return nil, err
+	}
+
+	snapshots, err := d.listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.Name != name {
+			continue
+		}
+		if s.VGName != source.VGName || s.Origin != source.LVName {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for another source volume %s", name, s.Origin)
+		}
+		return d.createSnapshotResponse(s, req.GetSourceVolumeId()), nil
+	}
+
+	d.log.Info("creating snapshot", "name", name, "source-volume-id", req.GetSourceVolumeId(), "vg", source.VGName)
+
+	output, err := d.lvm.CreateSnapshot(ctx, source.VGName, name, source.LVName)
+	unlockSource()
+	if err != nil {
+		return nil, statusError(err, "unable to create snapshot %s, output:%s", name, output)
+	}
+
+	snapshot, err := d.findSnapshot(ctx, d.newVolumeID(source.VGName, name))
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.Info("successfully created snapshot", "name", name)
+
+	return d.createSnapshotResponse(*snapshot, req.GetSourceVolumeId()), nil
+}
+
+func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if len(req.GetSnapshotId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id missing in request")
+	}
+
+	unlock, err := d.lockVolumes("DeleteSnapshot", req.GetSnapshotId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vid, err := parseVolumeID(req.GetSnapshotId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// snapshots of other nodes do not exist here, deleting them is a no-op as for every snapshot which does not exist
+	if err := d.verifyLocation(vid, req.GetSnapshotId()); err != nil {
+		d.log.Debug("snapshot does not belong to this node", "snapshot-id", req.GetSnapshotId(), "reason", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	snapshot, err := d.lookupSnapshot(ctx, req.GetSnapshotId())
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	// the volumes restored from the snapshot keep the pending tag until their copy completed
+	lvs, err := d.lvm.ListLVs(ctx, snapshot.VGName)
+	if err != nil {
+		return nil, statusError(err, "unable to list volumes")
+	}
+	for _, lv := range lvs {
+		if lv.HasTag(lvm.CopyPendingTag) && lv.ContentSource() == snapshotContentSource(snapshot.VGName, snapshot.Name) {
+			return nil, status.Errorf(codes.FailedPrecondition, "snapshot %s is still restored into volume %s", req.GetSnapshotId(), lv.Name)
+		}
+	}
+
+	d.log.Info("trying to delete snapshot", "snapshot-id", req.GetSnapshotId())
+
+	output, err := d.lvm.RemoveSnapshot(ctx, snapshot.VGName, snapshot.Name)
+	if err != nil {
+		return nil, statusError(err, "unable to delete snapshot with id %s, output:%s", req.GetSnapshotId(), output)
+	}
+
+	d.log.Info("snapshot successfully deleted", "snapshot-id", req.GetSnapshotId())
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	var snapshots []lvm.LogicalVolume
+
+	if req.GetSnapshotId() != "" {
+		// snapshots of other nodes or invalid ids simply do not match
+		snapshot, err := d.lookupSnapshot(ctx, req.GetSnapshotId())
+		if err != nil || snapshot == nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		snapshots = append(snapshots, *snapshot)
+	} else {
+		var err error
+		snapshots, err = d.listSnapshots(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.GetSourceVolumeId() != "" {
+		source, found, err := d.lookupVolume(ctx, req.GetSourceVolumeId())
+		if err != nil || !found {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		snapshots = slices.DeleteFunc(snapshots, func(s lvm.LogicalVolume) bool {
+			return s.VGName != source.VGName || s.Origin != source.LVName
+		})
+	}
+
+	start := 0
+	if req.GetStartingToken() != "" {
+		var err error
+		start, err = strconv.Atoi(req.GetStartingToken())
+		if err != nil || start < 0 || start > len(snapshots) {
+			return nil, status.Errorf(codes.Aborted, "invalid starting token %q", req.GetStartingToken())
+		}
+	}
+
+	end := len(snapshots)
+	if req.GetMaxEntries() > 0 && start+int(req.GetMaxEntries()) < end {
+		end = start + int(req.GetMaxEntries())
+	}
+
+	resp := &csi.ListSnapshotsResponse{}
+	for _, s := range snapshots[start:end] {
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{Snapshot: d.toCSISnapshot(s)})
+	}
+	if end < len(snapshots) {
+		resp.NextToken = strconv.Itoa(end)
+	}
+
+	return resp, nil
+}
+
+// lookupSnapshot returns the snapshot with the given id, nil is returned if it does not exist.
+// An error is returned if the id is invalid or belongs to another node or volume group.
+func (d *Driver) lookupSnapshot(ctx context.Context, id string) (*lvm.LogicalVolume, error) {
+	vid, err := parseVolumeID(id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := d.verifyLocation(vid, id); err != nil {
+		return nil, err
+	}
+
+	snapshots, err := d.listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range snapshots {
+		if s.Name == vid.LVName && (vid.VGName == "" || s.VGName == vid.VGName) {
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// findSnapshot returns the snapshot with the given id or a NotFound error if it does not exist on this node.
+func (d *Driver) findSnapshot(ctx context.Context, id string) (*lvm.LogicalVolume, error) {
+	snapshot, err := d.lookupSnapshot(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s not found", id)
+	}
+	return snapshot, nil
+}
+
+// snapshotContentSource returns the content source of volumes restored from the snapshot
+func snapshotContentSource(vg string, name string) string {
+	return fmt.Sprintf("snapshot:%s/%s", vg, name)
+}
+
+// snapshotLVName returns the name of the logical volume for the requested snapshot name.
+// lvm reserves names starting with "snapshot", which is exactly what the external-snapshotter uses.
+func snapshotLVName(name string) string {
+	if after, ok := strings.CutPrefix(name, "snapshot"); ok {
+		return "snap" + after
+	}
+	return name
+}
+
+func (d *Driver) toCSISnapshot(s lvm.LogicalVolume) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     d.newVolumeID(s.VGName, s.Name),
+		SourceVolumeId: d.newVolumeID(s.VGName, s.Origin),
+		SizeBytes:      s.OriginSize,
+		CreationTime:   timestamppb.New(s.CreationTime),
+		ReadyToUse:     !s.IsInvalidSnapshot(),
+	}
+}
+
+// createSnapshotResponse keeps the source volume id as it was requested, it might still be a plain id of an older volume.
+func (d *Driver) createSnapshotResponse(s lvm.LogicalVolume, sourceVolumeID string) *csi.CreateSnapshotResponse {
+	snapshot := d.toCSISnapshot(s)
+	snapshot.SourceVolumeId = sourceVolumeID
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}
+}
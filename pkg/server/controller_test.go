@@ -0,0 +1,1260 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	sourceVolumeID   = "v1:csi-lvm:pvc-source:n1"
+	sourceSnapshotID = "v1:csi-lvm:snap-source:n1"
+)
+
+func withSource(req *csi.CreateVolumeRequest, source *csi.VolumeContentSource) *csi.CreateVolumeRequest {
+	req.VolumeContentSource = source
+	return req
+}
+
+func withParameters(req *csi.CreateVolumeRequest, parameters map[string]string) *csi.CreateVolumeRequest {
+	maps.Copy(req.Parameters, parameters)
+	return req
+}
+
+func snapshotSource(id string) *csi.VolumeContentSource {
+	return &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id}}}
+}
+
+func volumeSource(id string) *csi.VolumeContentSource {
+	return &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id}}}
+}
+
+// withSourceVolume creates the volume pvc-source and its snapshot snapshot-source
+func withSourceVolume(t *testing.T, d *Driver, f *fake.Executor) {
+	createVolume(t, d, "pvc-source", 100*mib)
+	createSnapshot(t, d, "snapshot-source", sourceVolumeID)
+}
+
+func TestCreateVolume(t *testing.T) {
+	tests := []struct {
+		name         string
+		pvSizes      []int64
+		setup        func(t *testing.T, d *Driver, f *fake.Executor)
+		req          *csi.CreateVolumeRequest
+		wantCode     codes.Code
+		wantCapacity int64
+		// wantSegType and wantImages are checked if the segment type is set
+		wantSegType string
+		wantImages  int
+	}{
+		{
+			name:         "linear",
+			req:          createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCapacity: 100 * mib,
+		},
+		{
+			name:         "rounded up to whole extents",
+			req:          createVolumeRequest("pvc-1", "linear", 100*mib+1),
+			wantCapacity: 104 * mib,
+		},
+		{
+			name:         "rounded up to whole extents on every stripe",
+			pvSizes:      []int64{gib, gib, gib},
+			req:          createVolumeRequest("pvc-1", "striped", 100*mib),
+			wantCapacity: 108 * mib,
+		},
+		{
+			name:         "empty volume",
+			req:          createVolumeRequest("pvc-1", "linear", 0),
+			wantCapacity: 4 * mib,
+		},
+		{
+			name:         "mirror",
+			pvSizes:      []int64{gib, gib},
+			req:          createVolumeRequest("pvc-1", "mirror", 100*mib),
+			wantCapacity: 100 * mib,
+			wantSegType:  "raid1",
+			wantImages:   2,
+		},
+		{
+			name:         "raid1 with two mirrors",
+			pvSizes:      []int64{gib, gib, gib},
+			req:          withParameters(createVolumeRequest("pvc-1", "raid1", 100*mib), map[string]string{mirrorsParameter: "2"}),
+			wantCapacity: 100 * mib,
+			wantSegType:  "raid1",
+			wantImages:   3,
+		},
+		{
+			name:     "raid1 with more mirrors than physical volumes",
+			pvSizes:  []int64{gib, gib},
+			req:      withParameters(createVolumeRequest("pvc-1", "raid1", 100*mib), map[string]string{mirrorsParameter: "2"}),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			// two data stripes with one extent each
+			name:         "raid5",
+			pvSizes:      []int64{gib, gib, gib},
+			req:          createVolumeRequest("pvc-1", "raid5", 100*mib),
+			wantCapacity: 104 * mib,
+			wantSegType:  "raid5",
+			wantImages:   3,
+		},
+		{
+			name:         "raid6",
+			pvSizes:      []int64{gib, gib, gib, gib, gib},
+			req:          createVolumeRequest("pvc-1", "raid6", 100*mib),
+			wantCapacity: 108 * mib,
+			wantSegType:  "raid6",
+			wantImages:   5,
+		},
+		{
+			name:     "raid5 without enough physical volumes",
+			pvSizes:  []int64{gib, gib},
+			req:      createVolumeRequest("pvc-1", "raid5", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "raid6 without enough physical volumes",
+			pvSizes:  []int64{gib, gib, gib, gib},
+			req:      createVolumeRequest("pvc-1", "raid6", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:         "raid10",
+			pvSizes:      []int64{gib, gib, gib, gib},
+			req:          createVolumeRequest("pvc-1", "raid10", 100*mib),
+			wantCapacity: 104 * mib,
+			wantSegType:  "raid10",
+			wantImages:   4,
+		},
+		{
+			name:         "raid10 with stripe size",
+			pvSizes:      []int64{gib, gib, gib, gib, gib, gib},
+			req:          withParameters(createVolumeRequest("pvc-1", "raid10", 100*mib), map[string]string{stripesParameter: "3", stripeSizeParameter: "64Ki"}),
+			wantCapacity: 108 * mib,
+			wantSegType:  "raid10",
+			wantImages:   6,
+		},
+		{
+			name:     "raid10 without enough physical volumes",
+			pvSizes:  []int64{gib, gib, gib},
+			req:      createVolumeRequest("pvc-1", "raid10", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid mirrors",
+			pvSizes:  []int64{gib, gib},
+			req:      withParameters(createVolumeRequest("pvc-1", "raid1", 100*mib), map[string]string{mirrorsParameter: "0"}),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown type",
+			req:      createVolumeRequest("pvc-1", "raid7", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "missing name",
+			req:      createVolumeRequest("", "linear", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "volume id too long",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				d.nodeId = strings.Repeat("n", 112)
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "negative required bytes",
+			req:      createVolumeRequest("pvc-1", "linear", -1),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "required bytes exceed limit",
+			req: func() *csi.CreateVolumeRequest {
+				req := createVolumeRequest("pvc-1", "linear", 100*mib)
+				req.CapacityRange.LimitBytes = 50 * mib
+				return req
+			}(),
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name: "rounded size exceeds limit",
+			req: func() *csi.CreateVolumeRequest {
+				req := createVolumeRequest("pvc-1", "linear", 100*mib+1)
+				req.CapacityRange.LimitBytes = 100*mib + 1
+				return req
+			}(),
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "larger than the volume group",
+			req:      createVolumeRequest("pvc-1", "linear", 2*gib),
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "not enough free space",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-0", 800*mib)
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 500*mib),
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "lvcreate reports insufficient space",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Fail("lvcreate", `Volume group "csi-lvm" has insufficient free space (10 extents): 25 required.`)
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "lvcreate fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Fail("lvcreate", "Internal error: unexpected failure")
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCode: codes.Internal,
+		},
+		{
+			name: "lvcreate hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Hang("lvcreate")
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name: "retried request",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+			},
+			req:          createVolumeRequest("pvc-1", "linear", 100*mib),
+			wantCapacity: 100 * mib,
+		},
+		{
+			name: "retried request of a rounded size",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib+1)
+			},
+			req:          createVolumeRequest("pvc-1", "linear", 100*mib+1),
+			wantCapacity: 104 * mib,
+		},
+		{
+			name:    "retried mirror",
+			pvSizes: []int64{gib, gib},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				_, err := d.CreateVolume(context.Background(), createVolumeRequest("pvc-1", "mirror", 100*mib))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:          createVolumeRequest("pvc-1", "mirror", 100*mib),
+			wantCapacity: 100 * mib,
+			wantSegType:  "raid1",
+			wantImages:   2,
+		},
+		{
+			name: "existing volume with another size",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+			},
+			req:      createVolumeRequest("pvc-1", "linear", 200*mib),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "existing larger volume within the limit",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 200*mib)
+			},
+			req: func() *csi.CreateVolumeRequest {
+				req := createVolumeRequest("pvc-1", "linear", 100*mib)
+				req.CapacityRange.LimitBytes = 300 * mib
+				return req
+			}(),
+			wantCapacity: 200 * mib,
+		},
+		{
+			name: "existing volume which exceeds the limit",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 200*mib)
+			},
+			req: func() *csi.CreateVolumeRequest {
+				req := createVolumeRequest("pvc-1", "linear", 100*mib)
+				req.CapacityRange.LimitBytes = 150 * mib
+				return req
+			}(),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:    "existing volume with another type",
+			pvSizes: []int64{gib, gib},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+			},
+			req:      createVolumeRequest("pvc-1", "mirror", 100*mib),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:    "existing mirror without integrity",
+			pvSizes: []int64{gib, gib},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				_, err := d.CreateVolume(context.Background(), createVolumeRequest("pvc-1", "mirror", 100*mib))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:      withParameters(createVolumeRequest("pvc-1", "mirror", 100*mib), map[string]string{integrityParameter: "true"}),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:    "existing mirror with another number of images",
+			pvSizes: []int64{gib, gib, gib},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				_, err := d.CreateVolume(context.Background(), createVolumeRequest("pvc-1", "mirror", 100*mib))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:      withParameters(createVolumeRequest("pvc-1", "raid1", 100*mib), map[string]string{mirrorsParameter: "2"}),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:         "restore snapshot",
+			setup:        withSourceVolume,
+			req:          withSource(createVolumeRequest("pvc-1", "linear", 50*mib), snapshotSource(sourceSnapshotID)),
+			wantCapacity: 100 * mib,
+		},
+		{
+			name:         "clone volume",
+			setup:        withSourceVolume,
+			req:          withSource(createVolumeRequest("pvc-1", "linear", 100*mib), volumeSource(sourceVolumeID)),
+			wantCapacity: 100 * mib,
+		},
+		{
+			name:     "restore missing snapshot",
+			req:      withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID)),
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "restore fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				f.Fail("dd", "dd: error writing '/dev/csi-lvm/pvc-1': Input/output error")
+			},
+			req:      withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID)),
+			wantCode: codes.Internal,
+		},
+		{
+			name: "restore hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				f.Hang("dd")
+			},
+			req:      withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID)),
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name: "existing volume without content source",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				createVolume(t, d, "pvc-1", 100*mib)
+			},
+			req:      withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID)),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "existing volume with another content source",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				_, err := d.CreateVolume(context.Background(), withSource(createVolumeRequest("pvc-1", "linear", 100*mib), volumeSource(sourceVolumeID)))
+				if err != nil {
+					t.Fatalf("unable to clone volume: %v", err)
+				}
+			},
+			req:      withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID)),
+			wantCode: codes.AlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvSizes := tt.pvSizes
+			if len(pvSizes) == 0 {
+				pvSizes = []int64{gib}
+			}
+			d, f := newTestDriver(t, pvSizes...)
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+
+			// copies are only bounded by the request
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			resp, err := d.CreateVolume(ctx, tt.req)
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			if got := resp.GetVolume().GetCapacityBytes(); got != tt.wantCapacity {
+				t.Errorf("got capacity %d, want %d", got, tt.wantCapacity)
+			}
+			if got, want := resp.GetVolume().GetVolumeId(), "v1:csi-lvm:pvc-1:n1"; got != want {
+				t.Errorf("got volume id %q, want %q", got, want)
+			}
+
+			// the capacity is the size which lvm allocated
+			lv, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lv == nil {
+				t.Fatal("volume was not created")
+			}
+			if lv.Size != tt.wantCapacity {
+				t.Errorf("got volume of %d bytes, want %d", lv.Size, tt.wantCapacity)
+			}
+			if tt.wantSegType != "" && (lv.SegType != tt.wantSegType || lv.Stripes != tt.wantImages) {
+				t.Errorf("got %s volume with %d images, want %s with %d", lv.SegType, lv.Stripes, tt.wantSegType, tt.wantImages)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeRetriesInterruptedCopy(t *testing.T) {
+	d, f := newTestDriver(t, gib)
+	withSourceVolume(t, d, f)
+
+	// the partially copied volume is left behind if it can not be removed either
+	f.Fail("dd", "dd: error writing '/dev/csi-lvm/pvc-1': Input/output error")
+	f.Fail("lvremove", "Internal error: unexpected failure")
+
+	req := withSource(createVolumeRequest("pvc-1", "linear", 100*mib), snapshotSource(sourceSnapshotID))
+	_, err := d.CreateVolume(context.Background(), req)
+	checkCode(t, err, codes.Internal)
+
+	f.Recover("dd")
+	f.Recover("lvremove")
+
+	_, err = d.CreateVolume(context.Background(), req)
+	checkCode(t, err, codes.OK)
+
+	var copies int
+	for _, c := range f.Commands() {
+		if c[0] == "dd" {
+			copies++
+		}
+	}
+	if copies != 2 {
+		t.Errorf("got %d copies, want the interrupted copy to be repeated", copies)
+	}
+
+	lv, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.HasTag(lvm.CopyPendingTag) {
+		t.Errorf("copy of pvc-1 is still pending")
+	}
+}
+
+func TestCreateVolumeCopiesWithoutSourceLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *csi.VolumeContentSource
+		// during runs while the content is copied, after runs once the copy was canceled
+		during func(t *testing.T, d *Driver)
+		after  func(t *testing.T, d *Driver)
+	}{
+		{
+			name:   "clone",
+			source: volumeSource(sourceVolumeID),
+			during: func(t *testing.T, d *Driver) {
+				// the source can not be deleted while the temporary snapshot exists
+				_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: sourceVolumeID})
+				checkCode(t, err, codes.FailedPrecondition)
+			},
+			after: func(t *testing.T, d *Driver) {
+				clone, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1-clone")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if clone != nil {
+					t.Errorf("temporary snapshot of the clone was not removed")
+				}
+			},
+		},
+		{
+			name:   "restore",
+			source: snapshotSource(sourceSnapshotID),
+			during: func(t *testing.T, d *Driver) {
+				_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: sourceSnapshotID})
+				checkCode(t, err, codes.FailedPrecondition)
+			},
+			after: func(t *testing.T, d *Driver) {
+				// the partially restored volume was removed, so nothing needs the snapshot anymore
+				_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: sourceSnapshotID})
+				checkCode(t, err, codes.OK)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			withSourceVolume(t, d, f)
+
+			f.Hang("dd")
+			req := withSource(createVolumeRequest("pvc-1", "linear", 100*mib), tt.source)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				_, err := d.CreateVolume(ctx, req)
+				done <- err
+			}()
+
+			// wait for the copy to start
+			for !slices.ContainsFunc(f.Commands(), func(c []string) bool { return c[0] == "dd" }) {
+				select {
+				case err := <-done:
+					t.Fatalf("volume was created before its copy started: %v", err)
+				default:
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			// the source is not locked while it is copied, only the new volume is
+			createSnapshot(t, d, "snapshot-2", sourceVolumeID)
+			_, err := d.CreateVolume(context.Background(), req)
+			checkCode(t, err, codes.Aborted)
+			tt.during(t, d)
+
+			cancel()
+			checkCode(t, <-done, codes.Canceled)
+			tt.after(t, d)
+		})
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, d *Driver, f *fake.Executor)
+		id       string
+		wantCode codes.Code
+	}{
+		{
+			name:  "existing volume",
+			setup: withSourceVolume,
+			id:    "v1:csi-lvm:pvc-1:n1",
+		},
+		{
+			name: "missing volume",
+			id:   "v1:csi-lvm:pvc-1:n1",
+		},
+		{
+			name:     "invalid id",
+			id:       "v1:csi-lvm",
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "volume with snapshot",
+			setup:    withSourceVolume,
+			id:       sourceVolumeID,
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "volume in use",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				f.Fail("lvremove", `Logical volume csi-lvm/pvc-1 in use.`)
+			},
+			id:       "v1:csi-lvm:pvc-1:n1",
+			wantCode: codes.Aborted,
+		},
+		{
+			name: "lvremove hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				f.Hang("lvremove")
+			},
+			id:       "v1:csi-lvm:pvc-1:n1",
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+
+			_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: tt.id})
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			vid, found, err := d.lookupVolume(context.Background(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found {
+				t.Errorf("volume %s still exists", vid.LVName)
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	tests := []struct {
+		name          string
+		pvSizes       []int64
+		lvmType       string
+		block         bool
+		parameters    map[string]string
+		setup         func(t *testing.T, d *Driver, f *fake.Executor)
+		id            string
+		required      int64
+		limit         int64
+		wantCode      codes.Code
+		wantCapacity  int64
+		wantNodeStage bool
+		wantExtended  bool
+	}{
+		{
+			name:          "filesystem",
+			required:      200 * mib,
+			wantCapacity:  200 * mib,
+			wantNodeStage: true,
+			wantExtended:  true,
+		},
+		{
+			name:         "block",
+			block:        true,
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+			wantExtended: true,
+		},
+		{
+			name:          "block with filesystem",
+			block:         true,
+			parameters:    map[string]string{"resizeBlockFilesystem": "true"},
+			required:      200 * mib,
+			wantCapacity:  200 * mib,
+			wantNodeStage: true,
+			wantExtended:  true,
+		},
+		{
+			name:          "rounded up to whole extents",
+			required:      200*mib + 1,
+			wantCapacity:  204 * mib,
+			wantNodeStage: true,
+			wantExtended:  true,
+		},
+		{
+			name:          "rounded up to whole extents on every stripe",
+			pvSizes:       []int64{gib, gib},
+			lvmType:       "striped",
+			required:      202 * mib,
+			wantCapacity:  208 * mib,
+			wantNodeStage: true,
+			wantExtended:  true,
+		},
+		{
+			name:          "legacy id",
+			id:            "pvc-1",
+			required:      200 * mib,
+			wantCapacity:  200 * mib,
+			wantNodeStage: true,
+			wantExtended:  true,
+		},
+		{
+			name:          "already expanded",
+			required:      50 * mib,
+			wantCapacity:  100 * mib,
+			wantNodeStage: true,
+		},
+		{
+			// without peers the node of the volume extends it in NodeExpandVolume
+			name:          "volume of another node",
+			id:            "v1:csi-lvm:pvc-1:n2",
+			required:      200 * mib,
+			wantCapacity:  200 * mib,
+			wantNodeStage: true,
+		},
+		{
+			name:     "required bytes exceed limit",
+			required: 300 * mib,
+			limit:    200 * mib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "rounded size exceeds limit",
+			required: 200*mib + 1,
+			limit:    202 * mib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "volume exceeds limit",
+			required: 50 * mib,
+			limit:    50 * mib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "larger than the volume group",
+			required: 2 * gib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name: "not enough free space",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-2", 900*mib)
+			},
+			required: 200 * mib,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "mirror larger than its smaller physical volume",
+			pvSizes:  []int64{gib, 200 * mib},
+			lvmType:  "mirror",
+			required: 300 * mib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "negative required bytes",
+			required: -1,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid id",
+			id:       "v1:csi-lvm",
+			required: 200 * mib,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "missing volume",
+			id:       "v1:csi-lvm:pvc-2:n1",
+			required: 200 * mib,
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "lvextend hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Hang("lvextend")
+			},
+			required:     200 * mib,
+			wantCode:     codes.DeadlineExceeded,
+			wantExtended: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvSizes := tt.pvSizes
+			if pvSizes == nil {
+				pvSizes = []int64{gib}
+			}
+			d, f := newTestDriver(t, pvSizes...)
+
+			lvmType := tt.lvmType
+			if lvmType == "" {
+				lvmType = "linear"
+			}
+			capability := mountCapability()
+			if tt.block {
+				capability = blockCapability()
+			}
+			req := createVolumeRequest("pvc-1", lvmType, 100*mib)
+			req.VolumeCapabilities = []*csi.VolumeCapability{capability}
+			for k, v := range tt.parameters {
+				req.Parameters[k] = v
+			}
+			resp, err := d.CreateVolume(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+			id := tt.id
+			if id == "" {
+				id = resp.GetVolume().GetVolumeId()
+			}
+			before := len(f.Commands())
+
+			got, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:         id,
+				CapacityRange:    &csi.CapacityRange{RequiredBytes: tt.required, LimitBytes: tt.limit},
+				VolumeCapability: capability,
+			})
+			checkCode(t, err, tt.wantCode)
+
+			extended := slices.ContainsFunc(f.Commands()[before:], func(c []string) bool { return c[0] == "lvextend" })
+			if extended != tt.wantExtended {
+				t.Errorf("lvextend executed: %t, want %t", extended, tt.wantExtended)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.GetCapacityBytes() != tt.wantCapacity {
+				t.Errorf("got capacity %d, want %d", got.GetCapacityBytes(), tt.wantCapacity)
+			}
+			if got.GetNodeExpansionRequired() != tt.wantNodeStage {
+				t.Errorf("got node expansion required %t, want %t", got.GetNodeExpansionRequired(), tt.wantNodeStage)
+			}
+			if !tt.wantExtended {
+				return
+			}
+			lv, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lv.Size != tt.wantCapacity {
+				t.Errorf("got size %d of the logical volume, want %d", lv.Size, tt.wantCapacity)
+			}
+		})
+	}
+}
+
+// serveTestPeer serves the requests of other nodes for the driver and returns its address
+func serveTestPeer(t *testing.T, d *Driver) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := d.newPeerServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestControllerExpandVolumeOfOtherNode(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		id           string
+		required     int64
+		wantCode     codes.Code
+		wantCapacity int64
+	}{
+		{
+			name:         "forwarded to the node of the volume",
+			id:           "v1:csi-lvm:pvc-1:n2",
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+		},
+		{
+			name:     "rejected by the node of the volume",
+			id:       "v1:csi-lvm:pvc-1:n2",
+			required: 2 * gib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "missing volume on the node",
+			id:       "v1:csi-lvm:pvc-2:n2",
+			required: 200 * mib,
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "wrong token",
+			token:    "wrong",
+			id:       "v1:csi-lvm:pvc-1:n2",
+			required: 200 * mib,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unknown node",
+			id:       "v1:csi-lvm:pvc-1:n3",
+			required: 200 * mib,
+			wantCode: codes.Unavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, _ := newNodeTestDriver(t, "n2", PeerConfig{Port: 9899, Token: "secret", Address: func(ctx context.Context, node string) (string, error) {
+				return "", fmt.Errorf("node %s is not reachable", node)
+			}}, gib)
+			createVolume(t, owner, "pvc-1", 100*mib)
+			address := serveTestPeer(t, owner)
+
+			token := tt.token
+			if token == "" {
+				token = "secret"
+			}
+			d, f := newNodeTestDriver(t, testNode, PeerConfig{Port: 9899, Token: token, Address: func(ctx context.Context, node string) (string, error) {
+				if node != "n2" {
+					return "", fmt.Errorf("node %s not found", node)
+				}
+				return address, nil
+			}}, gib)
+			before := len(f.Commands())
+
+			got, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:         tt.id,
+				CapacityRange:    &csi.CapacityRange{RequiredBytes: tt.required},
+				VolumeCapability: mountCapability(),
+			})
+			checkCode(t, err, tt.wantCode)
+			if commands := f.Commands()[before:]; len(commands) != 0 {
+				t.Errorf("executed commands %v on the node which forwarded the request", commands)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.GetCapacityBytes() != tt.wantCapacity || !got.GetNodeExpansionRequired() {
+				t.Errorf("got capacity %d and node expansion required %t, want %d and true", got.GetCapacityBytes(), got.GetNodeExpansionRequired(), tt.wantCapacity)
+			}
+			lv, err := owner.lvm.GetLV(context.Background(), testVG, "pvc-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lv.Size != tt.wantCapacity {
+				t.Errorf("got size %d of the logical volume on the node of the volume, want %d", lv.Size, tt.wantCapacity)
+			}
+		})
+	}
+}
+
+func TestPeerServerDoesNotForward(t *testing.T) {
+	d, f := newNodeTestDriver(t, testNode, PeerConfig{Port: 9899, Token: "secret", Address: func(ctx context.Context, node string) (string, error) {
+		t.Errorf("request for node %s was forwarded again", node)
+		return "", fmt.Errorf("node %s not found", node)
+	}}, gib)
+	address := serveTestPeer(t, d)
+	before := len(f.Commands())
+
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), peerTokenKey, "secret")
+	_, err = csi.NewControllerClient(conn).ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "v1:csi-lvm:pvc-1:n2",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 200 * mib},
+	})
+	checkCode(t, err, codes.NotFound)
+
+	_, err = csi.NewControllerClient(conn).CreateVolume(ctx, createVolumeRequest("pvc-1", "linear", 100*mib))
+	checkCode(t, err, codes.Unimplemented)
+	if commands := f.Commands()[before:]; len(commands) != 0 {
+		t.Errorf("peer server executed commands %v", commands)
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	// every physical volume has 256 extents of 4MiB, raid images need one of them for their metadata
+	tests := []struct {
+		name        string
+		pvSizes     []int64
+		parameters  map[string]string
+		wantCode    codes.Code
+		wantBytes   int64
+		wantMaximum int64
+	}{
+		{name: "linear", pvSizes: []int64{gib, gib}, parameters: map[string]string{lvmTypeParameter: "linear"}, wantBytes: 2 * gib, wantMaximum: 2 * gib},
+		{name: "mirror", pvSizes: []int64{gib, gib}, parameters: map[string]string{lvmTypeParameter: "mirror"}, wantBytes: 1020 * mib, wantMaximum: 1020 * mib},
+		{name: "mirror on a single physical volume", pvSizes: []int64{gib}, parameters: map[string]string{lvmTypeParameter: "mirror"}, wantBytes: gib, wantMaximum: gib},
+		{name: "raid1 with two mirrors", pvSizes: []int64{gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid1", mirrorsParameter: "2"}, wantBytes: 1020 * mib, wantMaximum: 1020 * mib},
+		{name: "raid1 with more mirrors than physical volumes", pvSizes: []int64{gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid1", mirrorsParameter: "2"}},
+		{name: "striped", pvSizes: []int64{gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "striped"}, wantBytes: 3 * gib, wantMaximum: 3 * gib},
+		{name: "raid5", pvSizes: []int64{gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid5"}, wantBytes: 2040 * mib, wantMaximum: 2040 * mib},
+		{name: "raid5 without enough physical volumes", pvSizes: []int64{gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid5"}},
+		{name: "raid6", pvSizes: []int64{gib, gib, gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid6"}, wantBytes: 3060 * mib, wantMaximum: 3060 * mib},
+		{name: "raid6 without enough physical volumes", pvSizes: []int64{gib, gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid6"}},
+		{name: "raid10", pvSizes: []int64{gib, gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid10"}, wantBytes: 2040 * mib, wantMaximum: 2040 * mib},
+		{name: "raid10 without enough physical volumes", pvSizes: []int64{gib, gib, gib}, parameters: map[string]string{lvmTypeParameter: "raid10"}},
+		// on uneven physical volumes an image may span several of them, but the largest volume has to fit onto the smaller ones
+		{name: "mirror on uneven physical volumes", pvSizes: []int64{gib, 512 * mib, 256 * mib}, parameters: map[string]string{lvmTypeParameter: "mirror"}, wantBytes: 764 * mib, wantMaximum: 508 * mib},
+		{name: "mirror with integrity on uneven physical volumes", pvSizes: []int64{gib, 512 * mib}, parameters: map[string]string{lvmTypeParameter: "mirror", integrityParameter: "true"}, wantBytes: 504 * mib, wantMaximum: 504 * mib},
+		{name: "striped on uneven physical volumes", pvSizes: []int64{gib, 512 * mib, 256 * mib}, parameters: map[string]string{lvmTypeParameter: "striped", stripesParameter: "2"}, wantBytes: 1536 * mib, wantMaximum: gib},
+		{name: "raid5 on uneven physical volumes", pvSizes: []int64{gib, gib, 512 * mib, 512 * mib}, parameters: map[string]string{lvmTypeParameter: "raid5", stripesParameter: "2"}, wantBytes: 2040 * mib, wantMaximum: 1016 * mib},
+		{name: "raid10 on uneven physical volumes", pvSizes: []int64{gib, gib, 512 * mib, 512 * mib, 256 * mib}, parameters: map[string]string{lvmTypeParameter: "raid10"}, wantBytes: 1272 * mib, wantMaximum: 1016 * mib},
+		{name: "unknown type", pvSizes: []int64{gib}, parameters: map[string]string{lvmTypeParameter: "raid7"}, wantCode: codes.InvalidArgument},
+		{name: "invalid stripes", pvSizes: []int64{gib, gib}, parameters: map[string]string{lvmTypeParameter: "striped", stripesParameter: "x"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDriver(t, tt.pvSizes...)
+
+			got, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: tt.parameters})
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			if got.GetAvailableCapacity() != tt.wantBytes {
+				t.Errorf("got available capacity %d, want %d", got.GetAvailableCapacity(), tt.wantBytes)
+			}
+			if got.GetMaximumVolumeSize().GetValue() != tt.wantMaximum {
+				t.Errorf("got maximum volume size %d, want %d", got.GetMaximumVolumeSize().GetValue(), tt.wantMaximum)
+			}
+			if tt.wantMaximum == 0 {
+				return
+			}
+
+			// the maximum volume size can be created, but not a single extent more
+			d, _ = newTestDriver(t, tt.pvSizes...)
+			_, err = d.CreateVolume(context.Background(), withParameters(createVolumeRequest("pvc-1", "", tt.wantMaximum), tt.parameters))
+			if err != nil {
+				t.Errorf("unable to create volume of the maximum volume size: %v", err)
+			}
+			d, _ = newTestDriver(t, tt.pvSizes...)
+			_, err = d.CreateVolume(context.Background(), withParameters(createVolumeRequest("pvc-1", "", tt.wantMaximum+int64(fake.DefaultExtentSize)), tt.parameters))
+			checkCode(t, err, codes.ResourceExhausted)
+		})
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, d *Driver, f *fake.Executor)
+		req      *csi.CreateSnapshotRequest
+		wantCode codes.Code
+		wantID   string
+	}{
+		{
+			name: "snapshot",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+			},
+			req:    &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantID: "v1:csi-lvm:snap-1:n1",
+		},
+		{
+			name: "retried request",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				createSnapshot(t, d, "snapshot-1", "v1:csi-lvm:pvc-1:n1")
+			},
+			req:    &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantID: "v1:csi-lvm:snap-1:n1",
+		},
+		{
+			name: "existing snapshot of another volume",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				createVolume(t, d, "pvc-2", 100*mib)
+				createSnapshot(t, d, "snapshot-1", "v1:csi-lvm:pvc-2:n1")
+			},
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "missing source volume",
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "snapshot id too long",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				d.nodeId = strings.Repeat("n", 112)
+			},
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:" + strings.Repeat("n", 112)},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "source volume of another node",
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n2"},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "not enough free space",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 600*mib)
+			},
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "lvcreate hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-1", 100*mib)
+				f.Hang("lvcreate")
+			},
+			req:      &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+
+			resp, err := d.CreateSnapshot(context.Background(), tt.req)
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			if got := resp.GetSnapshot().GetSnapshotId(); got != tt.wantID {
+				t.Errorf("got snapshot id %q, want %q", got, tt.wantID)
+			}
+			if got := resp.GetSnapshot().GetSourceVolumeId(); got != tt.req.GetSourceVolumeId() {
+				t.Errorf("got source volume id %q, want %q", got, tt.req.GetSourceVolumeId())
+			}
+			if !resp.GetSnapshot().GetReadyToUse() {
+				t.Errorf("snapshot is not ready to use")
+			}
+		})
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, d *Driver, f *fake.Executor)
+		id       string
+		wantCode codes.Code
+	}{
+		{
+			name:  "existing snapshot",
+			setup: withSourceVolume,
+			id:    sourceSnapshotID,
+		},
+		{
+			name: "missing snapshot",
+			id:   sourceSnapshotID,
+		},
+		{
+			name:  "snapshot of another node",
+			setup: withSourceVolume,
+			id:    "v1:csi-lvm:snap-source:n2",
+		},
+		{
+			name: "lvremove fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				f.Fail("lvremove", "Internal error: unexpected failure")
+			},
+			id:       sourceSnapshotID,
+			wantCode: codes.Internal,
+		},
+		{
+			name: "lvremove hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				withSourceVolume(t, d, f)
+				f.Hang("lvremove")
+			},
+			id:       sourceSnapshotID,
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+
+			_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: tt.id})
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			snapshot, err := d.lookupSnapshot(context.Background(), sourceSnapshotID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot != nil && tt.id == sourceSnapshotID {
+				t.Errorf("snapshot %s still exists", snapshot.Name)
+			}
+		})
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	d, f := newTestDriver(t, gib)
+	withSourceVolume(t, d, f)
+
+	// the temporary snapshot of an interrupted clone is left behind, it is no snapshot of a user
+	f.Hang("dd")
+	f.Fail("lvremove", "Internal error: unexpected failure")
+	ctx, cancel := context.WithTimeout(context.Background(), testCommandTimeout)
+	defer cancel()
+	_, err := d.CreateVolume(ctx, withSource(createVolumeRequest("pvc-1", "linear", 100*mib), volumeSource(sourceVolumeID)))
+	checkCode(t, err, codes.DeadlineExceeded)
+	f.Recover("dd")
+	f.Recover("lvremove")
+
+	clone, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1-clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone == nil {
+		t.Fatal("temporary snapshot of the clone was removed")
+	}
+
+	tests := []struct {
+		name    string
+		req     *csi.ListSnapshotsRequest
+		wantIDs []string
+	}{
+		{
+			name:    "all",
+			req:     &csi.ListSnapshotsRequest{},
+			wantIDs: []string{sourceSnapshotID},
+		},
+		{
+			name:    "by id",
+			req:     &csi.ListSnapshotsRequest{SnapshotId: sourceSnapshotID},
+			wantIDs: []string{sourceSnapshotID},
+		},
+		{
+			name: "by id of another node",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: "v1:csi-lvm:snap-source:n2"},
+		},
+		{
+			name:    "by source volume",
+			req:     &csi.ListSnapshotsRequest{SourceVolumeId: sourceVolumeID},
+			wantIDs: []string{sourceSnapshotID},
+		},
+		{
+			name: "by other source volume",
+			req:  &csi.ListSnapshotsRequest{SourceVolumeId: "v1:csi-lvm:pvc-1:n1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := d.ListSnapshots(context.Background(), tt.req)
+			checkCode(t, err, codes.OK)
+
+			var ids []string
+			for _, e := range resp.GetEntries() {
+				ids = append(ids, e.GetSnapshot().GetSnapshotId())
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got snapshots %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
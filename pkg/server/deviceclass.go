@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultDeviceClass is the name of the device class which is used if a storage class does not specify one
+	DefaultDeviceClass = "default"
+
+	deviceClassParameter = "deviceClass"
+)
+
+// DeviceClass is a named set of devices which form a volume group on every node
+type DeviceClass struct {
+	Name           string `json:"name"`
+	VGName         string `json:"vgName"`
+	DevicesPattern string `json:"devicePattern"`
+}
+
+func validateDeviceClasses(deviceClasses []DeviceClass) error {
+	if len(deviceClasses) == 0 {
+		return fmt.Errorf("no device classes provided")
+	}
+
+	var (
+		names = map[string]bool{}
+		vgs   = map[string]bool{}
+	)
+	for _, dc := range deviceClasses {
+		if dc.Name == "" {
+			return fmt.Errorf("device class without name provided")
+		}
+		if dc.VGName == "" {
+			return fmt.Errorf("device class %q has no volume group name", dc.Name)
+		}
+		if names[dc.Name] {
+			return fmt.Errorf("device class %q is configured more than once", dc.Name)
+		}
+		if vgs[dc.VGName] {
+			return fmt.Errorf("volume group %q is used by more than one device class", dc.VGName)
+		}
+		names[dc.Name] = true
+		vgs[dc.VGName] = true
+	}
+
+	return nil
+}
+
+// deviceClass returns the device class with the given name, an empty name refers to the default device class.
+func (d *Driver) deviceClass(name string) (*DeviceClass, error) {
+	if name == "" {
+		name = DefaultDeviceClass
+	}
+
+	for _, dc := range d.deviceClasses {
+		if dc.Name == name {
+			return &dc, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.InvalidArgument, "unknown device class %q", name)
+}
+
+// vgNames returns the names of all volume groups managed by this driver
+func (d *Driver) vgNames() []string {
+	var names []string
+	for _, dc := range d.deviceClasses {
+		names = append(names, dc.VGName)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookupVG returns the volume group which holds the logical volume with the given name
+func (d *Driver) lookupVG(ctx context.Context, name string) (string, bool, error) {
+	for _, vg := range d.vgNames() {
+		lv, err := d.lvm.GetLV(ctx, vg, name)
+		if err != nil {
+			return "", false, statusError(err, "unable to lookup volume %s in vg %s", name, vg)
+		}
+		if lv != nil {
+			return vg, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// listSnapshots returns the snapshots of all volume groups managed by this driver
+func (d *Driver) listSnapshots(ctx context.Context) ([]lvm.LogicalVolume, error) {
+	var snapshots []lvm.LogicalVolume
+	for _, vg := range d.vgNames() {
+		s, err := d.lvm.ListSnapshots(ctx, vg)
+		if err != nil {
+			return nil, statusError(err, "unable to list snapshots of vg %s", vg)
+		}
+		snapshots = append(snapshots, s...)
+	}
+	slices.SortFunc(snapshots, func(a, b lvm.LogicalVolume) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snapshots, nil
+}
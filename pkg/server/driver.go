@@ -6,7 +6,6 @@ import (
 	"log/slog"
 	"net"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -20,8 +19,6 @@ var (
 )
 
 type Driver struct {
-	sync.Mutex
-
 	csi.UnimplementedNodeServer
 	csi.UnimplementedIdentityServer
 	csi.UnimplementedControllerServer
@@ -34,11 +31,17 @@ type Driver struct {
 	hostWritePath     string
 	ephemeral         bool
 	maxVolumesPerNode int64
-	devicesPattern    string
-	vgName            string
+	deviceClasses     []DeviceClass
+	lvm               *lvm.Client
+	volumeLocks       *volumeLocks
+	volumeConditions  *volumeConditions
+	peers             PeerConfig
+
+	thinPoolSizePercent int
+	thinOvercommitRatio float64
 }
 
-func NewDriver(log *slog.Logger, driverName, nodeId, endpoint string, hostWritePath string, ephemeral bool, maxVolumesPerNode int64, version string, devicesPattern string, vgName string) (*Driver, error) {
+func NewDriver(log *slog.Logger, driverName, nodeId, endpoint string, hostWritePath string, ephemeral bool, maxVolumesPerNode int64, version string, deviceClasses []DeviceClass, thinPoolSizePercent int, thinOvercommitRatio float64, commandTimeout time.Duration, executor lvm.Executor, peers PeerConfig) (*Driver, error) {
 	if driverName == "" {
 		return nil, fmt.Errorf("no driver name provided")
 	}
@@ -51,25 +54,40 @@ func NewDriver(log *slog.Logger, driverName, nodeId, endpoint string, hostWriteP
 	if version != "" {
 		vendorVersion = version
 	}
+	if thinPoolSizePercent <= 0 || thinPoolSizePercent > 100 {
+		return nil, fmt.Errorf("thin pool size must be between 1 and 100 percent")
+	}
+	if thinOvercommitRatio < 1 {
+		return nil, fmt.Errorf("thin overcommit ratio must be at least 1")
+	}
 
-	log.Info("ensuring vg setup")
+	if commandTimeout < 0 {
+		return nil, fmt.Errorf("command timeout must not be negative")
+	}
+	if executor == nil {
+		return nil, fmt.Errorf("no command executor provided")
+	}
 
-	vgexists := lvm.VgExists(log, vgName)
-	if !vgexists {
-		log.Info("vg not found", "vgName", vgName)
-		lvm.VgActivate(log)
-		// now check again for existing vg again
-		vgexists := lvm.VgExists(log, vgName)
-		if !vgexists {
-			log.Info("vg still not existing - creating...", "vgName", vgName)
-			_, err := lvm.CreateVG(log, vgName, devicesPattern)
-			if err != nil {
-				return nil, fmt.Errorf("unable to create initial volume group: %w", err)
-			}
+	if err := validateDeviceClasses(deviceClasses); err != nil {
+		return nil, err
+	}
+	if err := peers.validate(); err != nil {
+		return nil, err
+	}
+
+	client := lvm.New(log, executor, commandTimeout)
+
+	for _, dc := range deviceClasses {
+		log.Info("ensuring vg setup", "deviceClass", dc.Name, "vgName", dc.VGName)
+
+		// CreateVG activates or creates the volume group only if it does not exist yet
+		output, err := client.CreateVG(context.Background(), dc.VGName, dc.DevicesPattern)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create initial volume group for device class %s: %w output:%s", dc.Name, err, output)
 		}
 	}
 
-	log.Info("initializing driver", "name", driverName, "endpoint", endpoint, "hostWritePath", hostWritePath, "ephemeral", ephemeral, "maxVolumesPerNode", maxVolumesPerNode, "devicesPattern", devicesPattern, "vgName", vgName)
+	log.Info("initializing driver", "name", driverName, "endpoint", endpoint, "hostWritePath", hostWritePath, "ephemeral", ephemeral, "maxVolumesPerNode", maxVolumesPerNode, "deviceClasses", deviceClasses, "thinPoolSizePercent", thinPoolSizePercent, "thinOvercommitRatio", thinOvercommitRatio, "commandTimeout", commandTimeout.String(), "peerPort", peers.Port)
 
 	return &Driver{
 		log:               log,
@@ -80,11 +98,26 @@ func NewDriver(log *slog.Logger, driverName, nodeId, endpoint string, hostWriteP
 		hostWritePath:     hostWritePath,
 		ephemeral:         ephemeral,
 		maxVolumesPerNode: maxVolumesPerNode,
-		devicesPattern:    devicesPattern,
-		vgName:            vgName,
+		deviceClasses:     deviceClasses,
+		lvm:               client,
+		volumeLocks:       newVolumeLocks(),
+		volumeConditions:  newVolumeConditions(),
+		peers:             peers,
+
+		thinPoolSizePercent: thinPoolSizePercent,
+		thinOvercommitRatio: thinOvercommitRatio,
 	}, nil
 }
 
+// createLV creates the logical volume with the given layout and tags, thin volumes are allocated from the thin pool of the volume group.
+func (d *Driver) createLV(ctx context.Context, vg string, name string, size uint64, layout lvm.Layout, tags ...string) (string, error) {
+	if layout.Type != thinType {
+		return d.lvm.CreateLV(ctx, vg, name, size, layout, tags...)
+	}
+
+	return d.lvm.CreateThinLV(ctx, vg, lvm.ThinPoolName, name, size, d.thinPoolSizePercent, d.thinOvercommitRatio, tags...)
+}
+
 func (d *Driver) Run(ctx context.Context) {
 	_ = os.Remove(d.endpoint)
 
@@ -107,10 +140,30 @@ func (d *Driver) Run(ctx context.Context) {
 		}
 	}()
 
+	var peerServer *grpc.Server
+	if d.peers.enabled() {
+		peerListener, err := net.Listen("tcp", fmt.Sprintf(":%d", d.peers.Port))
+		if err != nil {
+			panic(err)
+		}
+
+		d.log.Info("starting grpc server for the other nodes", "port", d.peers.Port)
+
+		peerServer = d.newPeerServer()
+		go func() {
+			if err := peerServer.Serve(peerListener); err != nil {
+				d.log.Error("error serving grpc for the other nodes, server stopped", "error", err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 
 	d.log.Info("received signal, shutting down the server...")
 
+	if peerServer != nil {
+		peerServer.GracefulStop()
+	}
 	server.GracefulStop()
 
 	d.log.Info("server stopped gracefully")
@@ -123,6 +176,7 @@ func (d *Driver) requestInterceptorFn(ctx context.Context, req any, info *grpc.U
 	)
 
 	response, err := handler(ctx, req)
+	err = toStatus(err)
 
 	log.With("duration", time.Since(start).String())
 
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testNode = "n1"
+	testVG   = "csi-lvm"
+
+	mib = int64(1024 * 1024)
+	gib = 1024 * mib
+
+	// testCommandTimeout stops commands which were told to hang by the fake
+	testCommandTimeout = 100 * time.Millisecond
+)
+
+// newTestDriver returns a driver of node n1 whose default device class uses the volume group csi-lvm of the fake,
+// the volume group has a physical volume for every entry of pvSizes
+func newTestDriver(t *testing.T, pvSizes ...int64) (*Driver, *fake.Executor) {
+	t.Helper()
+
+	return newNodeTestDriver(t, testNode, PeerConfig{}, pvSizes...)
+}
+
+// newNodeTestDriver is like newTestDriver, but the driver belongs to the given node and reaches the other nodes with peers
+func newNodeTestDriver(t *testing.T, node string, peers PeerConfig, pvSizes ...int64) (*Driver, *fake.Executor) {
+	t.Helper()
+
+	f := fake.New()
+	err := f.AddVG(testVG, pvSizes...)
+	if err != nil {
+		t.Fatalf("unable to create vg: %v", err)
+	}
+
+	d, err := NewDriver(slog.New(slog.DiscardHandler), "lvm.csi.metal-stack.io", node, "unix:///csi/csi.sock", t.TempDir(), false, 0, "test",
+		[]DeviceClass{{Name: DefaultDeviceClass, VGName: testVG, DevicesPattern: "/dev/fake-csi-lvm-*"}}, 90, 10, testCommandTimeout, f, peers)
+	if err != nil {
+		t.Fatalf("unable to create driver: %v", err)
+	}
+
+	return d, f
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func createVolumeRequest(name string, volumeType string, required int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: required},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{lvmTypeParameter: volumeType},
+	}
+}
+
+// createVolume creates a linear volume and returns its id
+func createVolume(t *testing.T, d *Driver, name string, size int64) string {
+	t.Helper()
+
+	resp, err := d.CreateVolume(context.Background(), createVolumeRequest(name, "linear", size))
+	if err != nil {
+		t.Fatalf("unable to create volume %s: %v", name, err)
+	}
+
+	return resp.GetVolume().GetVolumeId()
+}
+
+// createSnapshot creates a snapshot of the volume and returns its id
+func createSnapshot(t *testing.T, d *Driver, name string, volumeID string) string {
+	t.Helper()
+
+	resp, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: name, SourceVolumeId: volumeID})
+	if err != nil {
+		t.Fatalf("unable to create snapshot %s: %v", name, err)
+	}
+
+	return resp.GetSnapshot().GetSnapshotId()
+}
+
+// checkCode fails the test if err does not carry the wanted grpc code
+func checkCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Fatalf("got code %s, want %s: %v", got, want, err)
+	}
+}
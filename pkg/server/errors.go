@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusCode returns the grpc code for errors of pkg/lvm and of the request context,
+// errors which already carry a grpc status keep their code
+func statusCode(err error) codes.Code {
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, lvm.ErrInsufficientSpace):
+		return codes.ResourceExhausted
+	case errors.Is(err, lvm.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, lvm.ErrInvalidArgument):
+		return codes.InvalidArgument
+	case errors.Is(err, lvm.ErrDeviceInUse):
+		return codes.FailedPrecondition
+	case errors.Is(err, lvm.ErrMountConflict):
+		// the volume is already published or staged at the path, but not in the requested way
+		return codes.AlreadyExists
+	case errors.Is(err, lvm.ErrBusy), errors.Is(err, lvm.ErrAlreadyExists):
+		// existing volumes are checked before they are created, lvm only reports them
+		// if they were created concurrently, which is still in progress
+		return codes.Aborted
+	default:
+		return codes.Internal
+	}
+}
+
+// statusError returns err as grpc status with the formatted message in front, the code is derived from err
+func statusError(err error, format string, a ...any) error {
+	return status.Errorf(statusCode(err), "%s: %v", fmt.Sprintf(format, a...), err)
+}
+
+// toStatus converts errors which were returned without a grpc status, so the sidecars never see codes.Unknown
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	return status.Error(statusCode(err), err.Error())
+}
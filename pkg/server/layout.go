@@ -0,0 +1,101 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// parameters of a StorageClass or inline volume which select how the logical volume is spread over the physical volumes
+const (
+	lvmTypeParameter    = "type"
+	mirrorsParameter    = "mirrors"
+	stripesParameter    = "stripes"
+	stripeSizeParameter = "stripeSize"
+	// integrityParameter protects the images of mirror and raid volumes with checksums
+	integrityParameter = "integrity"
+)
+
+// thinType is the lvm type of thin volumes, which are allocated from the thin pool of the volume group
+const thinType = "thin"
+
+// raidSyncParameter decides whether the images of mirror and raid volumes are synchronized after they are created
+const raidSyncParameter = "raidSync"
+
+const (
+	// raidSyncSkip creates mirrors without synchronizing them, this is the default
+	raidSyncSkip = "skip"
+	// raidSyncFull synchronizes the images in the background while the volume is already used
+	raidSyncFull = "full"
+	// raidSyncWait synchronizes the images and the volume is only staged or published once they are in sync
+	raidSyncWait = "wait"
+)
+
+// raidSync returns the raid sync policy of the parameters
+func raidSync(parameters map[string]string) (string, error) {
+	policy := parameters[raidSyncParameter]
+	switch policy {
+	case "":
+		return raidSyncSkip, nil
+	case raidSyncSkip, raidSyncFull, raidSyncWait:
+		return policy, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s, %s or %s", raidSyncParameter, policy, raidSyncSkip, raidSyncFull, raidSyncWait)
+	}
+}
+
+// volumeLayout returns the layout which is selected by the parameters, an InvalidArgument error is returned for
+// unknown lvm types and invalid mirrors or stripes. Whether there are enough physical volumes for the layout
+// is only known for a volume group.
+func volumeLayout(parameters map[string]string) (lvm.Layout, error) {
+	layout := lvm.Layout{Type: parameters[lvmTypeParameter]}
+
+	for key, target := range map[string]*int{mirrorsParameter: &layout.Mirrors, stripesParameter: &layout.Stripes} {
+		value, ok := parameters[key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return layout, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a positive number", key, value)
+		}
+		*target = n
+	}
+
+	if value, ok := parameters[stripeSizeParameter]; ok {
+		size, err := resource.ParseQuantity(value)
+		if err != nil || size.Value() < 1 {
+			return layout, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a positive size like 64Ki", stripeSizeParameter, value)
+		}
+		layout.StripeSize = size.Value()
+	}
+
+	policy, err := raidSync(parameters)
+	if err != nil {
+		return layout, err
+	}
+	layout.Sync = policy != raidSyncSkip
+
+	if value, ok := parameters[integrityParameter]; ok {
+		layout.Integrity, err = strconv.ParseBool(value)
+		if err != nil {
+			return layout, status.Errorf(codes.InvalidArgument, "unable to parse %s parameter to bool: %v", integrityParameter, err)
+		}
+	}
+
+	if layout.Type == thinType {
+		if layout != (lvm.Layout{Type: thinType}) {
+			return layout, status.Errorf(codes.InvalidArgument, "%s, %s, %s, %s and %s are not supported for thin volumes", mirrorsParameter, stripesParameter, stripeSizeParameter, raidSyncParameter, integrityParameter)
+		}
+		return layout, nil
+	}
+
+	if err := layout.Validate(); err != nil {
+		return layout, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// volumeLocks tracks the logical volumes which have an operation in flight
+type volumeLocks struct {
+	mu sync.Mutex
+	// inFlight maps the name of a logical volume to the operation which holds it
+	inFlight map[string]string
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{
+		inFlight: map[string]string{},
+	}
+}
+
+// tryAcquire locks all given volumes for the operation, nothing is locked if one of them is already held.
+// In this case the held volume and the operation which holds it are returned.
+func (l *volumeLocks) tryAcquire(operation string, names ...string) (string, string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, name := range names {
+		if holder, ok := l.inFlight[name]; ok {
+			return name, holder, false
+		}
+	}
+	for _, name := range names {
+		l.inFlight[name] = operation
+	}
+
+	return "", "", true
+}
+
+func (l *volumeLocks) release(names ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, name := range names {
+		delete(l.inFlight, name)
+	}
+}
+
+// lockVolumes locks the logical volumes of the given volume or snapshot ids for the operation, empty ids are skipped.
+// An Aborted error is returned if another operation for one of them is in flight, as the CSI spec recommends.
+// The returned function releases the locks, it may be called more than once, so a lock can be released early and still be deferred.
+func (d *Driver) lockVolumes(operation string, ids ...string) (func(), error) {
+	var names []string
+	for _, id := range ids {
+		if id != "" {
+			names = append(names, lockName(id))
+		}
+	}
+
+	name, holder, ok := d.volumeLocks.tryAcquire(operation, names...)
+	if !ok {
+		return nil, status.Errorf(codes.Aborted, "an operation (%s) for volume %s is already in progress", holder, name)
+	}
+
+	return sync.OnceFunc(func() { d.volumeLocks.release(names...) }), nil
+}
+
+// lockName returns the name of the logical volume an id refers to, so volume ids, snapshot ids and plain
+// names of the same logical volume share one lock. Invalid ids are locked as they are and rejected by the caller.
+func lockName(id string) string {
+	vid, err := parseVolumeID(id)
+	if err != nil {
+		return id
+	}
+	return vid.LVName
+}
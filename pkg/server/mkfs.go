@@ -0,0 +1,131 @@
+package server
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parameters of a StorageClass or inline volume which are passed to mkfs when the volume is formatted
+const (
+	mkfsParameterPrefix                = "mkfs"
+	mkfsBlockSizeParameter             = "mkfsBlockSize"
+	mkfsLabelParameter                 = "mkfsLabel"
+	mkfsReservedBlocksPercentParameter = "mkfsReservedBlocksPercentage"
+	mkfsInodeRatioParameter            = "mkfsInodeRatio"
+	mkfsReflinkParameter               = "mkfsReflink"
+)
+
+// defaultFSType is used if a volume capability does not specify a filesystem
+const defaultFSType = "ext4"
+
+// fsckPolicyParameter decides whether an existing filesystem is checked or repaired before it is mounted
+const fsckPolicyParameter = "fsckPolicy"
+
+// fsckPolicy returns the policy of the parameters, filesystems are not checked by default
+func fsckPolicy(parameters map[string]string) (lvm.FsckPolicy, error) {
+	policy := lvm.FsckPolicy(parameters[fsckPolicyParameter])
+	switch policy {
+	case "":
+		return lvm.FsckNever, nil
+	case lvm.FsckNever, lvm.FsckCheck, lvm.FsckRepair:
+		return policy, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s, %s or %s", fsckPolicyParameter, policy, lvm.FsckNever, lvm.FsckCheck, lvm.FsckRepair)
+	}
+}
+
+// mkfsParameters are the filesystems which support each of the mkfs parameters
+var mkfsParameters = map[string][]string{
+	mkfsBlockSizeParameter:             {"ext3", "ext4", "xfs"},
+	mkfsLabelParameter:                 {"ext3", "ext4", "xfs", "btrfs", "f2fs"},
+	mkfsReservedBlocksPercentParameter: {"ext3", "ext4"},
+	mkfsInodeRatioParameter:            {"ext3", "ext4"},
+	mkfsReflinkParameter:               {"xfs"},
+}
+
+// mkfsArgs returns the arguments for mkfs of the filesystem type which are given by the mkfs parameters,
+// an InvalidArgument error is returned if the filesystem is not supported or a parameter is unknown, invalid
+// or not supported by the filesystem.
+func mkfsArgs(fsType string, parameters map[string]string) ([]string, error) {
+	if fsType == "" {
+		fsType = defaultFSType
+	}
+	if !slices.Contains(lvm.Filesystems, fsType) {
+		return nil, status.Errorf(codes.InvalidArgument, "filesystem %s is not supported, supported are %s", fsType, strings.Join(lvm.Filesystems, ", "))
+	}
+
+	var args []string
+	for _, key := range slices.Sorted(maps.Keys(parameters)) {
+		if !strings.HasPrefix(key, mkfsParameterPrefix) {
+			continue
+		}
+		fsTypes, ok := mkfsParameters[key]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown parameter %s, supported are %s", key, strings.Join(slices.Sorted(maps.Keys(mkfsParameters)), ", "))
+		}
+		if !slices.Contains(fsTypes, fsType) {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s is not supported for filesystem %s", key, fsType)
+		}
+
+		value := parameters[key]
+		invalid := func(format string, a ...any) error {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %q: "+format, append([]any{key, value}, a...)...)
+		}
+		switch key {
+		case mkfsBlockSizeParameter:
+			size, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || size < 1024 || size > 65536 || size&(size-1) != 0 {
+				return nil, invalid("must be a power of two between 1024 and 65536")
+			}
+			if fsType == "xfs" {
+				args = append(args, "-b", "size="+value)
+			} else {
+				args = append(args, "-b", value)
+			}
+		case mkfsLabelParameter:
+			maxLength, flag := 16, "-L"
+			switch fsType {
+			case "xfs":
+				maxLength = 12
+			case "btrfs":
+				maxLength = 255
+			case "f2fs":
+				maxLength, flag = 512, "-l"
+			}
+			if value == "" || len(value) > maxLength {
+				return nil, invalid("must have between 1 and %d characters for filesystem %s", maxLength, fsType)
+			}
+			args = append(args, flag, value)
+		case mkfsReservedBlocksPercentParameter:
+			percentage, err := strconv.ParseFloat(value, 64)
+			if err != nil || percentage < 0 || percentage > 50 {
+				return nil, invalid("must be a number between 0 and 50")
+			}
+			args = append(args, "-m", value)
+		case mkfsInodeRatioParameter:
+			ratio, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || ratio < 1024 || ratio > 67108864 {
+				return nil, invalid("must be the number of bytes per inode between 1024 and 67108864")
+			}
+			args = append(args, "-i", value)
+		case mkfsReflinkParameter:
+			reflink, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, invalid("must be true or false")
+			}
+			if reflink {
+				args = append(args, "-m", "reflink=1")
+			} else {
+				args = append(args, "-m", "reflink=0")
+			}
+		}
+	}
+
+	return args, nil
+}
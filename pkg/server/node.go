@@ -1,8 +1,11 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -32,6 +35,12 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Error(codes.InvalidArgument, "target path missing in request")
 	}
 
+	unlock, err := d.lockVolumes("NodePublishVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	targetPath := req.GetTargetPath()
 
 	if req.GetVolumeCapability().GetBlock() != nil &&
@@ -57,6 +66,8 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	ephemeralVolume := req.GetVolumeContext()["csi.storage.k8s.io/ephemeral"] == "true" ||
 		req.GetVolumeContext()["csi.storage.k8s.io/ephemeral"] == "" && d.ephemeral // Kubernetes 1.15 doesn't have csi.storage.k8s.io/ephemeral.
 
+	var vgName, lvName string
+
 	// if ephemeral is specified, create volume here
 	if ephemeralVolume {
 		size, err := parseSize(req.GetVolumeContext()["size"])
@@ -64,36 +75,102 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 
+		layout, err := volumeLayout(req.GetVolumeContext())
+		if err != nil {
+			return nil, err
+		}
+
+		dc, err := d.deviceClass(req.GetVolumeContext()[deviceClassParameter])
+		if err != nil {
+			return nil, err
+		}
+
 		volID := req.GetVolumeId()
 
-		output, err := lvm.CreateVG(d.log, d.vgName, d.devicesPattern)
+		output, err := d.lvm.CreateVG(ctx, dc.VGName, dc.DevicesPattern)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create vg: %w output:%s", err, output)
+			return nil, statusError(err, "unable to create vg, output:%s", output)
 		}
 
-		output, err = lvm.CreateLV(d.log, d.vgName, volID, size, req.GetVolumeContext()["type"], false)
+		output, err = d.createLV(ctx, dc.VGName, volID, size, layout)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create lv: %w output:%s", err, output)
+			return nil, statusError(err, "unable to create lv, output:%s", output)
 		}
 
-		d.log.Info("ephemeral mode: created volume", "volume", volID, "size", size)
+		d.log.Info("ephemeral mode: created volume", "volume", volID, "size", size, "device-class", dc.Name)
+
+		vgName = dc.VGName
+		lvName = volID
+	} else {
+		vid, err := d.existingVolume(ctx, req.GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		vgName = vid.VGName
+		lvName = vid.LVName
+	}
+
+	// staged volumes were already checked when they were staged
+	if req.GetVolumeCapability().GetBlock() != nil || ephemeralVolume {
+		err := d.waitForSync(ctx, vgName, lvName, req.GetVolumeContext())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if req.GetVolumeCapability().GetBlock() != nil {
-		output, err := lvm.BindMountLV(d.log, req.GetVolumeId(), targetPath, d.vgName)
+		readOnly := req.GetReadonly() || readOnlyAccessMode(req.GetVolumeCapability())
+		err := d.setBlockPermission(ctx, vgName, lvName, targetPath, readOnly)
 		if err != nil {
-			return nil, fmt.Errorf("unable to bind mount lv: %w output:%s", err, output)
+			return nil, err
+		}
+		options, err := mountOptions(nil, readOnly)
+		if err != nil {
+			return nil, err
+		}
+		output, err := d.lvm.BindMountLV(ctx, lvName, targetPath, vgName, options)
+		if err != nil {
+			return nil, statusError(err, "unable to bind mount lv, output:%s", output)
+		}
+		if req.GetVolumeContext()[rootDirectoryModeParameter] == legacyRootDirectoryMode {
+			err = os.Chmod(targetPath, 0777|os.ModeSetgid)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "unable to change permissions of %s: %v", targetPath, err)
+			}
 		}
 		// FIXME: VolumeCapability is a struct and not the size
-		d.log.Info("block lv", "id", req.GetVolumeId(), "size", req.GetVolumeCapability(), "vg", d.vgName, "devices", d.devicesPattern, "created at", targetPath)
+		d.log.Info("block lv", "id", req.GetVolumeId(), "size", req.GetVolumeCapability(), "vg", vgName, "created at", targetPath)
 
-	} else if req.GetVolumeCapability().GetMount() != nil {
-		output, err := lvm.MountLV(d.log, req.GetVolumeId(), targetPath, d.vgName, req.GetVolumeCapability().GetMount().GetFsType())
+	} else if req.GetVolumeCapability().GetMount() != nil && ephemeralVolume {
+		// ephemeral volumes are not staged by kubelet
+		err := d.mountFilesystem(ctx, vgName, lvName, targetPath, req.GetVolumeCapability().GetMount(), req.GetVolumeContext(), req.GetReadonly())
 		if err != nil {
-			return nil, fmt.Errorf("unable to mount lv: %w output:%s", err, output)
+			return nil, err
 		}
 		// FIXME: VolumeCapability is a struct and not the size
-		d.log.Info("mounted lv", "id", req.GetVolumeId(), "size", req.GetVolumeCapability(), "vg", d.vgName, "devices", d.devicesPattern, "created at", targetPath)
+		d.log.Info("mounted lv", "id", req.GetVolumeId(), "size", req.GetVolumeCapability(), "vg", vgName, "created at", targetPath)
+	} else if req.GetVolumeCapability().GetMount() != nil {
+		stagingPath := req.GetStagingTargetPath()
+		if len(stagingPath) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "staging target path missing in request")
+		}
+
+		options, err := mountOptions(nil, req.GetReadonly())
+		if err != nil {
+			return nil, err
+		}
+
+		// volumes which were staged by a previous version of this driver are not mounted at the staging path yet
+		err = d.mountFilesystem(ctx, vgName, lvName, stagingPath, req.GetVolumeCapability().GetMount(), req.GetVolumeContext(), readOnlyAccessMode(req.GetVolumeCapability()))
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := d.lvm.BindMount(ctx, stagingPath, targetPath, options)
+		if err != nil {
+			return nil, statusError(err, "unable to bind mount staged lv, output:%s", output)
+		}
+		d.log.Info("published lv", "id", req.GetVolumeId(), "vg", vgName, "staging path", stagingPath, "created at", targetPath)
 	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
@@ -111,21 +188,119 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 		return nil, status.Error(codes.InvalidArgument, "target path missing in request")
 	}
 
-	lvm.UmountLV(d.log, req.GetTargetPath())
+	unlock, err := d.lockVolumes("NodeUnpublishVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = d.lvm.Unmount(ctx, req.GetTargetPath())
+	if err != nil {
+		return nil, statusError(err, "unable to unpublish volume %s", volID)
+	}
+	// the target is a directory for filesystem volumes and a file for block volumes, both were created on publish
+	err = os.Remove(req.GetTargetPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, status.Errorf(codes.Internal, "unable to remove target path %s: %v", req.GetTargetPath(), err)
+	}
+
+	err = d.restoreBlockPermission(ctx, volID)
+	if err != nil {
+		return nil, err
+	}
 
 	// ephemeral volumes start with "csi-"
 	if strings.HasPrefix(volID, "csi-") {
-		// remove ephemeral volume here
-		output, err := lvm.RemoveLVS(d.log, d.vgName, volID)
+		vgName, found, err := d.lookupVG(ctx, volID)
 		if err != nil {
-			return nil, fmt.Errorf("unable to delete lv: %w output:%s", err, output)
+			return nil, err
+		}
+		if found {
+			// remove ephemeral volume here
+			output, err := d.lvm.RemoveLVS(ctx, vgName, volID)
+			if err != nil {
+				return nil, statusError(err, "unable to delete lv, output:%s", output)
+			}
+			d.volumeConditions.delete(volID)
+			d.log.Info("lv deleted", "id", volID, "vg", vgName)
 		}
-		d.log.Info("lv deleted", "id", volID, "vg", d.vgName)
 	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// setBlockPermission makes the device of a block volume read-only before it is published read-only.
+// A ro bind mount of a device node still lets the pod open the device for writing, so the permission is changed
+// on the logical volume. It applies to all targets of the volume, which therefore must all have the same access mode.
+func (d *Driver) setBlockPermission(ctx context.Context, vgName string, lvName string, targetPath string, readOnly bool) error {
+	mounts, err := d.lvm.DeviceBindMounts(ctx, vgName, lvName)
+	if err != nil {
+		return statusError(err, "unable to list targets of volume %s/%s", vgName, lvName)
+	}
+	for _, m := range mounts {
+		if m.ReadOnly() == readOnly {
+			continue
+		}
+		if m.Target == filepath.Clean(targetPath) {
+			return status.Errorf(codes.AlreadyExists, "volume %s/%s is already published at %s with different access mode", vgName, lvName, m.Target)
+		}
+		return status.Errorf(codes.FailedPrecondition, "volume %s/%s is already published at %s with different access mode", vgName, lvName, m.Target)
+	}
+
+	lv, err := d.lvm.GetLV(ctx, vgName, lvName)
+	if err != nil {
+		return statusError(err, "unable to lookup volume %s/%s", vgName, lvName)
+	}
+	if lv == nil {
+		return status.Errorf(codes.NotFound, "volume %s/%s not found", vgName, lvName)
+	}
+	if lv.IsReadOnly() == readOnly {
+		return nil
+	}
+
+	output, err := d.lvm.SetLVPermission(ctx, vgName, lvName, readOnly)
+	if err != nil {
+		return statusError(err, "unable to change permission of lv, output:%s", output)
+	}
+	d.log.Info("changed permission of lv", "vg", vgName, "lv", lvName, "read-only", readOnly)
+	return nil
+}
+
+// restoreBlockPermission makes a read-only block volume writable again once it is not published anymore,
+// nothing is done for volumes of other nodes or volumes which do not exist.
+func (d *Driver) restoreBlockPermission(ctx context.Context, id string) error {
+	vid, found, err := d.lookupVolume(ctx, id)
+	if status.Code(err) == codes.NotFound || (err == nil && !found) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+	if err != nil {
+		return statusError(err, "unable to lookup volume %s", id)
+	}
+	if lv == nil || !lv.IsReadOnly() {
+		return nil
+	}
+
+	mounts, err := d.lvm.DeviceBindMounts(ctx, vid.VGName, vid.LVName)
+	if err != nil {
+		return statusError(err, "unable to list targets of volume %s", id)
+	}
+	if len(mounts) > 0 {
+		return nil
+	}
+
+	output, err := d.lvm.SetLVPermission(ctx, vid.VGName, vid.LVName, false)
+	if err != nil {
+		return statusError(err, "unable to change permission of lv, output:%s", output)
+	}
+	d.log.Info("changed permission of lv", "vg", vid.VGName, "lv", vid.LVName, "read-only", false)
+	return nil
+}
+
 func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
@@ -138,9 +313,165 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, status.Error(codes.InvalidArgument, "volume Capability missing in request")
 	}
 
+	// block volumes have no filesystem, they are bind mounted from the device on publish
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	unlock, err := d.lockVolumes("NodeStageVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vid, err := d.existingVolume(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.waitForSync(ctx, vid.VGName, vid.LVName, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.mountFilesystem(ctx, vid.VGName, vid.LVName, req.GetStagingTargetPath(), req.GetVolumeCapability().GetMount(), req.GetVolumeContext(), readOnlyAccessMode(req.GetVolumeCapability()))
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.Info("staged lv", "id", req.GetVolumeId(), "vg", vid.VGName, "staging path", req.GetStagingTargetPath())
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// waitForSync returns an Unavailable error while the images of a raid volume whose raidSync policy is wait
+// are not in sync yet, kubelet retries the request until they are. The progress is logged for all policies.
+func (d *Driver) waitForSync(ctx context.Context, vgName string, lvName string, volumeContext map[string]string) error {
+	policy, err := raidSync(volumeContext)
+	if err != nil {
+		return err
+	}
+
+	lv, err := d.lvm.GetLV(ctx, vgName, lvName)
+	if err != nil {
+		return statusError(err, "unable to lookup lv %s", lvName)
+	}
+	if lv == nil {
+		return status.Errorf(codes.NotFound, "lv %s not found", lvName)
+	}
+	if !lv.IsSyncing() {
+		return nil
+	}
+
+	d.log.Info("raid volume is synchronizing", "lv", lvName, "sync-percent", lv.SyncPercent, "raid-sync", policy)
+	if policy != raidSyncWait {
+		return nil
+	}
+
+	return status.Errorf(codes.Unavailable, "lv %s is still synchronizing, %.2f%% in sync", lvName, lv.SyncPercent)
+}
+
+// mountFilesystem formats or checks the logical volume if needed and mounts it at path with the mount flags and the mkfs, fsck
+// and permission parameters of the volume context. The root directory is owned by the volume mount group if it is given.
+func (d *Driver) mountFilesystem(ctx context.Context, vgName string, lvName string, path string, mount *csi.VolumeCapability_MountVolume, volumeContext map[string]string, readOnly bool) error {
+	options, err := mountOptions(mount.GetMountFlags(), readOnly)
+	if err != nil {
+		return err
+	}
+	existing, err := d.lvm.FilesystemType(ctx, vgName, lvName)
+	if err != nil {
+		return statusError(err, "unable to inspect lv %s", lvName)
+	}
+	// the filesystem and mkfs parameters only apply to blank volumes, existing filesystems are mounted as they are
+	var formatArgs []string
+	if existing == "" {
+		formatArgs, err = mkfsArgs(mount.GetFsType(), volumeContext)
+		if err != nil {
+			return err
+		}
+	}
+	gid, err := parseMountGroup(mount.GetVolumeMountGroup())
+	if err != nil {
+		return err
+	}
+	policy, err := fsckPolicy(volumeContext)
+	if err != nil {
+		return err
+	}
+
+	result, err := d.lvm.CheckFilesystem(ctx, vgName, lvName, policy)
+	if errors.Is(err, lvm.ErrCorrupted) {
+		d.volumeConditions.set(lvName, true, err.Error())
+		return status.Errorf(codes.DataLoss, "refusing to mount lv %s: %v", lvName, err)
+	}
+	if err != nil {
+		return statusError(err, "unable to check filesystem of lv %s", lvName)
+	}
+	if result != nil {
+		message := fmt.Sprintf("%s filesystem check passed", result.FSType)
+		if result.Repaired {
+			message = fmt.Sprintf("%s filesystem errors were repaired", result.FSType)
+		}
+		d.log.Info("checked filesystem", "lv", lvName, "policy", policy, "repaired", result.Repaired, "output", result.Output)
+		d.volumeConditions.set(lvName, false, message)
+	}
+
+	output, err := d.lvm.MountLV(ctx, lvName, path, vgName, mount.GetFsType(), formatArgs, options)
+	if err != nil {
+		return statusError(err, "unable to mount lv, output:%s", output)
+	}
+
+	// the permissions of a read-only filesystem can not be changed
+	if readOnly {
+		return nil
+	}
+
+	return setRootPermissions(path, gid, volumeContext)
+}
+
+// unsupportedMountOptions would change how the driver mounts the volume
+var unsupportedMountOptions = []string{"bind", "rbind", "move", "remount", "shared", "rshared", "private", "rprivate", "slave", "rslave", "unbindable", "runbindable"}
+
+// mountOptions validates the mount flags of a volume capability and adds ro for read-only volumes.
+// A flag may contain several options separated by comma.
+func mountOptions(mountFlags []string, readOnly bool) ([]string, error) {
+	var options []string
+	for _, flag := range mountFlags {
+		for option := range strings.SplitSeq(flag, ",") {
+			option = strings.TrimSpace(option)
+			switch {
+			case option == "":
+				continue
+			case strings.ContainsAny(option, " \t\n\"'"):
+				return nil, status.Errorf(codes.InvalidArgument, "mount option %q must not contain whitespace or quotes", option)
+			case slices.Contains(unsupportedMountOptions, option):
+				return nil, status.Errorf(codes.InvalidArgument, "mount option %q is not supported", option)
+			case option == "rw" && readOnly:
+				return nil, status.Errorf(codes.InvalidArgument, "mount option %q conflicts with a read-only volume", option)
+			}
+			if !slices.Contains(options, option) {
+				options = append(options, option)
+			}
+		}
+	}
+
+	if readOnly && !slices.Contains(options, "ro") {
+		options = append(options, "ro")
+	}
+
+	return options, nil
+}
+
+// readOnlyAccessMode returns true if the volume may only be read by all nodes and pods
+func readOnlyAccessMode(capability *csi.VolumeCapability) bool {
+	switch capability.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	default:
+		return false
+	}
+}
+
 func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
@@ -150,6 +481,18 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 		return nil, status.Error(codes.InvalidArgument, "target path missing in request")
 	}
 
+	unlock, err := d.lockVolumes("NodeUnstageVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = d.lvm.Unmount(ctx, req.GetStagingTargetPath())
+	if err != nil {
+		return nil, statusError(err, "unable to unstage volume %s", req.GetVolumeId())
+	}
+	d.log.Info("unstaged lv", "id", req.GetVolumeId(), "staging path", req.GetStagingTargetPath())
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -189,6 +532,20 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
 		},
 	}, nil
 }
@@ -197,8 +554,11 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, in *csi.NodeGetVolumeSt
 	var fs unix.Statfs_t
 
 	err := unix.Statfs(in.GetVolumePath(), &fs)
+	if errors.Is(err, unix.ENOENT) {
+		return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", in.GetVolumePath())
+	}
 	if err != nil {
-		return nil, err
+		return nil, status.Errorf(codes.Internal, "unable to get filesystem statistics of %s: %v", in.GetVolumePath(), err)
 	}
 
 	diskFree := int64(fs.Bfree) * int64(fs.Bsize)   // nolint:gosec
@@ -222,6 +582,7 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, in *csi.NodeGetVolumeSt
 				Unit:      csi.VolumeUsage_INODES,
 			},
 		},
+		VolumeCondition: d.volumeCondition(ctx, in.GetVolumeId()),
 	}, nil
 }
 
@@ -233,7 +594,14 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume ID missing in request")
 	}
-	capacity := int64(req.GetCapacityRange().GetRequiredBytes())
+	required := req.GetCapacityRange().GetRequiredBytes()
+	limit := req.GetCapacityRange().GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "required and limit bytes must not be negative")
+	}
+	if limit > 0 && required > limit {
+		return nil, status.Errorf(codes.OutOfRange, "required bytes %d exceed limit of %d bytes", required, limit)
+	}
 
 	volID := req.GetVolumeId()
 	volPath := req.GetVolumePath()
@@ -241,6 +609,12 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 		return nil, status.Error(codes.InvalidArgument, "volume path not provided")
 	}
 
+	unlock, err := d.lockVolumes("NodeExpandVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	info, err := os.Stat(volPath)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "could not get file information from %s: %v", volPath, err)
@@ -249,20 +623,117 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 	isBlock := false
 	m := info.Mode()
 	if !m.IsDir() {
-		d.log.Warn("volume expand request on block device: filesystem resize has to be done externally")
+		d.log.Info("volume expand request on block device, its filesystem is only grown if resizeBlockFilesystem was set")
 		isBlock = true
 	}
 
-	output, err := lvm.ExtendLVS(d.log, d.vgName, volID, uint64(capacity), isBlock) //nolint:gosec
+	vid, err := d.existingVolume(ctx, volID)
 	if err != nil {
-		return nil, fmt.Errorf("unable to umount lv: %w output:%s", err, output)
+		return nil, err
+	}
 
+	// a previous attempt or ControllerExpandVolume may have extended the logical volume already, its filesystem is grown anyway
+	_, size, err := d.expansionSize(ctx, volID, vid, required, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	mountPath := volPath
+	if isBlock {
+		mountPath = ""
+	}
+
+	output, err := d.lvm.ExtendLVS(ctx, vid.VGName, vid.LVName, uint64(size), mountPath) //nolint:gosec
+	if err != nil {
+		return nil, statusError(err, "unable to extend lv, output:%s", output)
+	}
+
+	lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+	if err != nil {
+		return nil, statusError(err, "unable to lookup volume %s", volID)
+	}
+	if lv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volID)
 	}
 
 	return &csi.NodeExpandVolumeResponse{
-		CapacityBytes: capacity,
+		CapacityBytes: lv.Size,
 	}, nil
+}
+
+// expansionSize returns the logical volume of volID and the size it has to grow to for required bytes, rounded up to whole extents.
+// The size is the current one if the logical volume is already large enough, otherwise it is checked that the volume fits into its volume group.
+func (d *Driver) expansionSize(ctx context.Context, volID string, vid volumeID, required int64, limit int64) (*lvm.LogicalVolume, int64, error) {
+	lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+	if err != nil {
+		return nil, 0, statusError(err, "unable to lookup volume %s", volID)
+	}
+	if lv == nil {
+		return nil, 0, status.Errorf(codes.NotFound, "volume %s not found", volID)
+	}
+	if limit > 0 && lv.Size > limit {
+		return nil, 0, status.Errorf(codes.OutOfRange, "volume %s has already %d bytes, which exceeds the limit of %d bytes", volID, lv.Size, limit)
+	}
+
+	size := lv.Size
+	if lv.Size < required {
+		vg, err := d.lvm.GetVG(ctx, lv.VGName)
+		if err != nil {
+			return nil, 0, statusError(err, "unable to get vg %s", lv.VGName)
+		}
+		if vg == nil {
+			return nil, 0, status.Errorf(codes.NotFound, "vg %s does not exist", lv.VGName)
+		}
+
+		// lvm rounds the size up to whole extents on every stripe
+		size = lv.RoundSize(required, vg.ExtentSize)
+		if limit > 0 && size > limit {
+			return nil, 0, status.Errorf(codes.OutOfRange, "required bytes %d are rounded up to %d bytes by the extents of vg %s, which exceeds the limit of %d bytes", required, size, vg.Name, limit)
+		}
+		if err := d.checkExpansion(ctx, vg, lv, size); err != nil {
+			return nil, 0, err
+		}
+
+		d.log.Info("expanding volume", "volume-id", volID, "size", lv.Size, "required-bytes", required, "rounded-bytes", size)
+	}
+
+	return lv, size, nil
+}
+
+// checkExpansion returns OutOfRange if the logical volume can never grow to size in the physical volumes of its volume group
+// and ResourceExhausted if there are currently not enough free extents left to grow it.
+func (d *Driver) checkExpansion(ctx context.Context, vg *lvm.VolumeGroup, lv *lvm.LogicalVolume, size int64) error {
+	if lv.IsThin() {
+		capacity, err := d.lvm.ThinCapacity(ctx, lv.VGName, lv.PoolLV, d.thinPoolSizePercent, d.thinOvercommitRatio)
+		if err != nil {
+			return statusError(err, "unable to get capacity of thin pool %s", lv.PoolLV)
+		}
+		if size-lv.Size > capacity {
+			return status.Errorf(codes.ResourceExhausted, "thin pool %s has only %d bytes left for %d additional bytes of volume %s", lv.PoolLV, capacity, size-lv.Size, lv.Name)
+		}
+		return nil
+	}
+
+	pvs, err := d.lvm.ListPVs(ctx, vg.Name)
+	if err != nil {
+		return statusError(err, "unable to list pvs of vg %s", vg.Name)
+	}
+	extents := make([]int64, 0, len(pvs))
+	free := make([]int64, 0, len(pvs))
+	for _, pv := range pvs {
+		extents = append(extents, pv.ExtentCount)
+		free = append(free, pv.ExtentCount-pv.AllocatedExtentCount)
+	}
+
+	// every image of mirrors, striped and raid volumes grows on its own physical volume
+	if !lv.FitsInto(size, extents, vg.ExtentSize) {
+		return status.Errorf(codes.OutOfRange, "volume %s of type %s with %d images does not fit into the physical volumes of vg %s with %d bytes", lv.Name, lv.SegType, max(lv.Stripes, 1), vg.Name, size)
+	}
+	if !lv.CanExtend(size, free, vg.ExtentSize) {
+		return status.Errorf(codes.ResourceExhausted, "the physical volumes of vg %s have not enough free extents to grow volume %s of type %s with %d images to %d bytes", vg.Name, lv.Name, lv.SegType, max(lv.Stripes, 1), size)
+	}
 
+	return nil
 }
 
 func parseSize(val string) (uint64, error) {
@@ -0,0 +1,835 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm"
+	"github.com/metal-stack/csi-driver-lvm/pkg/lvm/fake"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	testVolumeID = "v1:csi-lvm:pvc-1:n1"
+	testDevice   = "/dev/csi-lvm/pvc-1"
+)
+
+// nodeTestPaths are the staging and target paths of a test, they are created by the driver
+type nodeTestPaths struct {
+	staging string
+	target  string
+}
+
+func newNodeTestPaths(t *testing.T) nodeTestPaths {
+	dir := t.TempDir()
+	return nodeTestPaths{staging: filepath.Join(dir, "staging"), target: filepath.Join(dir, "target")}
+}
+
+// stageVolume stages the filesystem volume at the staging path
+func stageVolume(t *testing.T, d *Driver, paths nodeTestPaths, volumeContext map[string]string) {
+	t.Helper()
+
+	_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: paths.staging,
+		VolumeCapability:  mountCapability(),
+		VolumeContext:     volumeContext,
+	})
+	if err != nil {
+		t.Fatalf("unable to stage volume: %v", err)
+	}
+}
+
+// publishBlockVolume publishes the block volume at the target path
+func publishBlockVolume(t *testing.T, d *Driver, paths nodeTestPaths, readOnly bool) {
+	t.Helper()
+
+	_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       paths.target,
+		VolumeCapability: blockCapability(),
+		Readonly:         readOnly,
+	})
+	if err != nil {
+		t.Fatalf("unable to publish volume: %v", err)
+	}
+}
+
+// formatVolume formats the volume with ext4 like a previous stage did
+func formatVolume(t *testing.T, f *fake.Executor) {
+	t.Helper()
+
+	_, _, err := f.Execute(context.Background(), "mkfs.ext4", testDevice)
+	if err != nil {
+		t.Fatalf("unable to format volume: %v", err)
+	}
+}
+
+// fsCapability is a mount capability with the given filesystem
+func fsCapability(fsType string) *csi.VolumeCapability {
+	capability := mountCapability()
+	capability.GetMount().FsType = fsType
+	return capability
+}
+
+func TestNodeStageVolume(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths)
+		capability    *csi.VolumeCapability
+		volumeContext map[string]string
+		id            string
+		wantCode      codes.Code
+		wantMounted   bool
+	}{
+		{
+			name:        "filesystem",
+			capability:  mountCapability(),
+			wantMounted: true,
+		},
+		{
+			name: "retried request",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+			},
+			capability:  mountCapability(),
+			wantMounted: true,
+		},
+		{
+			name:       "block volumes are not staged",
+			capability: blockCapability(),
+		},
+		{
+			name: "existing filesystem which is not supported for new volumes",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				_, _, err := f.Execute(context.Background(), "mkfs.vfat", testDevice)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			capability:  mountCapability(),
+			wantMounted: true,
+		},
+		{
+			name: "existing filesystem with mkfs parameters of another filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				_, _, err := f.Execute(context.Background(), "mkfs.ext2", testDevice)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{mkfsReflinkParameter: "true"},
+			wantMounted:   true,
+		},
+		{
+			name:       "unsupported filesystem for a blank volume",
+			capability: fsCapability("vfat"),
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name: "corrupted filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				formatVolume(t, f)
+				f.Corrupt(testDevice, false)
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{fsckPolicyParameter: string(lvm.FsckCheck)},
+			wantCode:      codes.DataLoss,
+		},
+		{
+			name: "unclean filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				formatVolume(t, f)
+				f.DirtyJournal(testDevice)
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{fsckPolicyParameter: string(lvm.FsckCheck)},
+			wantMounted:   true,
+		},
+		{
+			name: "raid volume is not in sync",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				err := f.SetSyncPercent(testVG, "pvc-1", 50)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{raidSyncParameter: raidSyncWait},
+			wantCode:      codes.Unavailable,
+		},
+		{
+			name: "raid volume is in sync",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				err := f.SetSyncPercent(testVG, "pvc-1", 100)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{raidSyncParameter: raidSyncWait},
+			wantMounted:   true,
+		},
+		{
+			name: "raid volume synchronizes in the background",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				err := f.SetSyncPercent(testVG, "pvc-1", 50)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			capability:    mountCapability(),
+			volumeContext: map[string]string{raidSyncParameter: raidSyncFull},
+			wantMounted:   true,
+		},
+		{
+			name:       "missing volume",
+			capability: mountCapability(),
+			id:         "v1:csi-lvm:pvc-2:n1",
+			wantCode:   codes.NotFound,
+		},
+		{
+			name:       "volume of another node",
+			capability: mountCapability(),
+			id:         "v1:csi-lvm:pvc-1:n2",
+			wantCode:   codes.NotFound,
+		},
+		{
+			name: "mkfs fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				f.Fail("mkfs.ext4", "mkfs.ext4: Device size reported to be zero.")
+			},
+			capability: mountCapability(),
+			wantCode:   codes.Internal,
+		},
+		{
+			name: "mount hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				f.Hang("mount")
+			},
+			capability: mountCapability(),
+			wantCode:   codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib, gib)
+			paths := newNodeTestPaths(t)
+			_, err := d.CreateVolume(context.Background(), createVolumeRequest("pvc-1", "mirror", 100*mib))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, d, f, paths)
+			}
+			id := tt.id
+			if id == "" {
+				id = testVolumeID
+			}
+
+			_, err = d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          id,
+				StagingTargetPath: paths.staging,
+				VolumeCapability:  tt.capability,
+				VolumeContext:     tt.volumeContext,
+			})
+			checkCode(t, err, tt.wantCode)
+
+			device, mounted := f.Mounts()[paths.staging]
+			if mounted != tt.wantMounted {
+				t.Fatalf("got mounted %t, want %t", mounted, tt.wantMounted)
+			}
+			if mounted && device != testDevice {
+				t.Errorf("got %s mounted at the staging path, want %s", device, testDevice)
+			}
+		})
+	}
+}
+
+func TestNodeUnstageVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths)
+		wantCode    codes.Code
+		wantMounted bool
+	}{
+		{
+			name: "staged volume",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+			},
+		},
+		{
+			name: "volume which is not staged",
+		},
+		{
+			name: "filesystem in use",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+				f.Fail("umount", "umount: "+paths.staging+": target is busy.")
+			},
+			wantCode:    codes.Aborted,
+			wantMounted: true,
+		},
+		{
+			name: "umount hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+				f.Hang("umount")
+			},
+			wantCode:    codes.DeadlineExceeded,
+			wantMounted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			paths := newNodeTestPaths(t)
+			createVolume(t, d, "pvc-1", 100*mib)
+			if tt.setup != nil {
+				tt.setup(t, d, f, paths)
+			}
+
+			_, err := d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: paths.staging})
+			checkCode(t, err, tt.wantCode)
+
+			if _, mounted := f.Mounts()[paths.staging]; mounted != tt.wantMounted {
+				t.Errorf("got mounted %t, want %t", mounted, tt.wantMounted)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths)
+		req          func(paths nodeTestPaths) *csi.NodePublishVolumeRequest
+		wantCode     codes.Code
+		wantMounted  bool
+		wantReadOnly bool
+	}{
+		{
+			name: "block",
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability()}
+			},
+			wantMounted: true,
+		},
+		{
+			name: "read-only block",
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability(), Readonly: true}
+			},
+			wantMounted:  true,
+			wantReadOnly: true,
+		},
+		{
+			name: "read-only block next to a writable target",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, nodeTestPaths{target: paths.target + "-other"}, false)
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability(), Readonly: true}
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "writable block next to a read-only target",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, nodeTestPaths{target: paths.target + "-other"}, true)
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability()}
+			},
+			wantCode:     codes.FailedPrecondition,
+			wantReadOnly: true,
+		},
+		{
+			name: "read-only block at a writable target",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, paths, false)
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability(), Readonly: true}
+			},
+			wantCode:    codes.AlreadyExists,
+			wantMounted: true,
+		},
+		{
+			name: "staged filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: paths.staging, TargetPath: paths.target, VolumeCapability: mountCapability()}
+			},
+			wantMounted: true,
+		},
+		{
+			name: "filesystem without staging path",
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: mountCapability()}
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "missing volume",
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: "v1:csi-lvm:pvc-2:n1", TargetPath: paths.target, VolumeCapability: blockCapability()}
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "bind mount fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				f.Fail("mount", "mount: permission denied")
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability()}
+			},
+			wantCode: codes.Internal,
+		},
+		{
+			name: "bind mount hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				f.Hang("mount")
+			},
+			req: func(paths nodeTestPaths) *csi.NodePublishVolumeRequest {
+				return &csi.NodePublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target, VolumeCapability: blockCapability()}
+			},
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			paths := newNodeTestPaths(t)
+			createVolume(t, d, "pvc-1", 100*mib)
+			if tt.setup != nil {
+				tt.setup(t, d, f, paths)
+			}
+
+			_, err := d.NodePublishVolume(context.Background(), tt.req(paths))
+			checkCode(t, err, tt.wantCode)
+
+			device, mounted := f.Mounts()[paths.target]
+			if mounted != tt.wantMounted {
+				t.Fatalf("got mounted %t, want %t", mounted, tt.wantMounted)
+			}
+			if mounted && device != testDevice {
+				t.Errorf("got %s mounted at the target path, want %s", device, testDevice)
+			}
+
+			lv, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lv.IsReadOnly() != tt.wantReadOnly {
+				t.Errorf("got read-only volume %t, want %t", lv.IsReadOnly(), tt.wantReadOnly)
+			}
+		})
+	}
+}
+
+func TestNodeUnpublishReadOnlyBlockVolume(t *testing.T) {
+	d, _ := newTestDriver(t, gib)
+	createVolume(t, d, "pvc-1", 100*mib)
+	targets := []string{filepath.Join(t.TempDir(), "target-1"), filepath.Join(t.TempDir(), "target-2")}
+	for _, target := range targets {
+		publishBlockVolume(t, d, nodeTestPaths{target: target}, true)
+	}
+
+	// the volume stays read-only until its last target is unpublished
+	for i, target := range targets {
+		_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: target})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lv, err := d.lvm.GetLV(context.Background(), testVG, "pvc-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < len(targets)-1; lv.IsReadOnly() != want {
+			t.Errorf("got read-only volume %t after unpublishing %s, want %t", lv.IsReadOnly(), target, want)
+		}
+	}
+}
+
+func TestNodeUnpublishVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths)
+		id          string
+		wantCode    codes.Code
+		wantMounted bool
+		wantTarget  bool
+	}{
+		{
+			name: "published filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				stageVolume(t, d, paths, nil)
+				_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+					VolumeId:          testVolumeID,
+					StagingTargetPath: paths.staging,
+					TargetPath:        paths.target,
+					VolumeCapability:  mountCapability(),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "published block volume",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, paths, false)
+			},
+		},
+		{
+			name: "target which is already unmounted",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				err := os.Mkdir(paths.target, 0750)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "missing target path",
+		},
+		{
+			name: "volume which was already unpublished",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, paths, false)
+				_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: paths.target})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "missing volume",
+			id:   "v1:csi-lvm:pvc-2:n1",
+		},
+		{
+			name: "target in use",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				publishBlockVolume(t, d, paths, false)
+				f.Fail("umount", "umount: "+paths.target+": target is busy.")
+			},
+			wantCode:    codes.Aborted,
+			wantMounted: true,
+			wantTarget:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib)
+			paths := newNodeTestPaths(t)
+			createVolume(t, d, "pvc-1", 100*mib)
+			if tt.setup != nil {
+				tt.setup(t, d, f, paths)
+			}
+			id := tt.id
+			if id == "" {
+				id = testVolumeID
+			}
+
+			_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: id, TargetPath: paths.target})
+			checkCode(t, err, tt.wantCode)
+
+			if _, mounted := f.Mounts()[paths.target]; mounted != tt.wantMounted {
+				t.Errorf("got mounted %t, want %t", mounted, tt.wantMounted)
+			}
+			if _, err := os.Stat(paths.target); (err == nil) != tt.wantTarget {
+				t.Errorf("got target path %v, want existing %t", err, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths)
+		missingPath   bool
+		wantCode      codes.Code
+		wantCondition *csi.VolumeCondition
+	}{
+		{
+			name: "raid in sync",
+		},
+		{
+			name: "raid out of sync",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				err := f.SetSyncPercent(testVG, "pvc-1", 42.5)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCondition: &csi.VolumeCondition{Message: "raid is synchronizing, 42.50% in sync"},
+		},
+		{
+			name: "checked filesystem",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				formatVolume(t, f)
+				stageVolume(t, d, paths, map[string]string{fsckPolicyParameter: string(lvm.FsckCheck)})
+			},
+			wantCondition: &csi.VolumeCondition{Message: "ext4 filesystem check passed"},
+		},
+		{
+			name: "checked filesystem of a raid out of sync",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor, paths nodeTestPaths) {
+				formatVolume(t, f)
+				stageVolume(t, d, paths, map[string]string{fsckPolicyParameter: string(lvm.FsckCheck)})
+				err := f.SetSyncPercent(testVG, "pvc-1", 42.5)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCondition: &csi.VolumeCondition{Message: "ext4 filesystem check passed, raid is synchronizing, 42.50% in sync"},
+		},
+		{
+			name:        "missing volume path",
+			missingPath: true,
+			wantCode:    codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, f := newTestDriver(t, gib, gib)
+			paths := newNodeTestPaths(t)
+			_, err := d.CreateVolume(context.Background(), createVolumeRequest("pvc-1", "mirror", 100*mib))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, d, f, paths)
+			}
+			// the fake does not mount anything, the statistics are the ones of the directory
+			if !tt.missingPath {
+				err = os.MkdirAll(paths.target, 0750)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: testVolumeID, VolumePath: paths.target})
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			if len(got.GetUsage()) != 2 {
+				t.Errorf("got usage %v, want bytes and inodes", got.GetUsage())
+			}
+			condition := got.GetVolumeCondition()
+			if condition.GetAbnormal() != tt.wantCondition.GetAbnormal() || condition.GetMessage() != tt.wantCondition.GetMessage() {
+				t.Errorf("got condition %v, want %v", condition, tt.wantCondition)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	tests := []struct {
+		name         string
+		pvSizes      []int64
+		lvmType      string
+		block        bool
+		parameters   map[string]string
+		setup        func(t *testing.T, d *Driver, f *fake.Executor)
+		required     int64
+		limit        int64
+		wantCode     codes.Code
+		wantCapacity int64
+		// wantCommand is a command which has to grow the filesystem
+		wantCommand string
+	}{
+		{
+			name:         "filesystem",
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+		},
+		{
+			name:         "block",
+			block:        true,
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+		},
+		{
+			name:       "block with filesystem which is not in use",
+			block:      true,
+			parameters: map[string]string{"resizeBlockFilesystem": "true"},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				formatVolume(t, f)
+			},
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+			wantCommand:  "resize2fs",
+		},
+		{
+			name:       "block with xfs which is not in use",
+			block:      true,
+			parameters: map[string]string{"resizeBlockFilesystem": "true"},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				_, _, err := f.Execute(context.Background(), "mkfs.xfs", testDevice)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+			wantCommand:  "xfs_growfs",
+		},
+		{
+			name:       "block with filesystem in use",
+			block:      true,
+			parameters: map[string]string{"resizeBlockFilesystem": "true"},
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				// the pod formatted the device and opened it
+				formatVolume(t, f)
+				f.Open(testDevice)
+			},
+			required: 200 * mib,
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:         "already expanded",
+			required:     50 * mib,
+			wantCapacity: 100 * mib,
+		},
+		{
+			name:         "rounded up to whole extents",
+			required:     200*mib + 1,
+			wantCapacity: 204 * mib,
+		},
+		{
+			name:     "rounded size exceeds limit",
+			required: 200*mib + 1,
+			limit:    200*mib + 1,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "larger than the volume group",
+			required: 2 * gib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name: "not enough free space",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				createVolume(t, d, "pvc-2", 900*mib)
+			},
+			required: 200 * mib,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:         "mirror",
+			pvSizes:      []int64{gib, gib},
+			lvmType:      "mirror",
+			required:     200 * mib,
+			wantCapacity: 200 * mib,
+		},
+		{
+			// the volume group has enough extents, but the second image has to stay on the small physical volume
+			name:     "mirror larger than its smaller physical volume",
+			pvSizes:  []int64{gib, 200 * mib},
+			lvmType:  "mirror",
+			required: 300 * mib,
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:    "mirror without free extents for its second image",
+			pvSizes: []int64{gib, gib},
+			lvmType: "mirror",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				// fills the second physical volume, the first one still has 920MiB free
+				createVolume(t, d, "pvc-2", 1000*mib)
+			},
+			required: 200 * mib,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "lvextend fails",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Fail("lvextend", "Internal error: unexpected failure")
+			},
+			required: 200 * mib,
+			wantCode: codes.Internal,
+		},
+		{
+			name: "lvextend hangs",
+			setup: func(t *testing.T, d *Driver, f *fake.Executor) {
+				f.Hang("lvextend")
+			},
+			required: 200 * mib,
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvSizes := tt.pvSizes
+			if pvSizes == nil {
+				pvSizes = []int64{gib}
+			}
+			d, f := newTestDriver(t, pvSizes...)
+			paths := newNodeTestPaths(t)
+
+			lvmType := tt.lvmType
+			if lvmType == "" {
+				lvmType = "linear"
+			}
+			req := createVolumeRequest("pvc-1", lvmType, 100*mib)
+			for k, v := range tt.parameters {
+				req.Parameters[k] = v
+			}
+			if tt.block {
+				req.VolumeCapabilities = []*csi.VolumeCapability{blockCapability()}
+			}
+			_, err := d.CreateVolume(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// kubelet passes the staging path of filesystem volumes and the target path of block volumes
+			volumePath := paths.staging
+			if tt.block {
+				publishBlockVolume(t, d, paths, false)
+				volumePath = paths.target
+			} else {
+				stageVolume(t, d, paths, nil)
+			}
+			if tt.setup != nil {
+				tt.setup(t, d, f)
+			}
+
+			resp, err := d.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:      testVolumeID,
+				VolumePath:    volumePath,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: tt.required, LimitBytes: tt.limit},
+			})
+			checkCode(t, err, tt.wantCode)
+			if err != nil {
+				return
+			}
+
+			if got := resp.GetCapacityBytes(); got != tt.wantCapacity {
+				t.Errorf("got capacity %d, want %d", got, tt.wantCapacity)
+			}
+			if tt.wantCommand != "" && !slices.ContainsFunc(f.Commands(), func(c []string) bool { return c[0] == tt.wantCommand }) {
+				t.Errorf("%s was not executed", tt.wantCommand)
+			}
+			// the block volume is only mounted temporarily to grow its filesystem
+			if mounts := f.Mounts(); len(mounts) != 1 {
+				t.Errorf("got mounts %v, want only the target path", mounts)
+			}
+		})
+	}
+}
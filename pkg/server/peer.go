@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// peerTokenKey is the metadata key of the token which authenticates requests between node plugins
+const peerTokenKey = "x-csi-driver-lvm-peer-token"
+
+// PeerConfig configures how the node plugins reach each other. The resizer runs only once in the cluster,
+// but a logical volume can only be extended on the node of its volume group, so ControllerExpandVolume
+// forwards requests for volumes of other nodes to the node plugin of their node.
+type PeerConfig struct {
+	// Port on which the node plugin serves the requests of the other nodes, 0 disables forwarding
+	Port int
+	// Token authenticates the node plugins among each other, it has to be the same on all nodes
+	Token string
+	// Address returns host:port of the node plugin on the given node
+	Address func(ctx context.Context, node string) (string, error)
+}
+
+func (c PeerConfig) enabled() bool {
+	return c.Port > 0
+}
+
+func (c PeerConfig) validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("peer port %d is not between 0 and 65535", c.Port)
+	}
+	if !c.enabled() {
+		return nil
+	}
+	if c.Token == "" {
+		return fmt.Errorf("no peer token provided")
+	}
+	if c.Address == nil {
+		return fmt.Errorf("no lookup of peer addresses provided")
+	}
+	return nil
+}
+
+// peerServer serves the expansion requests which other node plugins forward to this node, all other calls are unimplemented
+type peerServer struct {
+	csi.UnimplementedControllerServer
+
+	d *Driver
+}
+
+// ControllerExpandVolume only expands volumes of this node, so a forwarded request is never forwarded again.
+func (p *peerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if err := checkExpandVolumeRequest(req); err != nil {
+		return nil, err
+	}
+	return p.d.expandVolume(ctx, req)
+}
+
+// newPeerServer returns the grpc server for the requests of the other node plugins, which have to send the peer token
+func (d *Driver) newPeerServer() *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(d.authenticatePeer, d.requestInterceptorFn))
+	csi.RegisterControllerServer(server, &peerServer{d: d})
+	return server
+}
+
+func (d *Driver) authenticatePeer(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(peerTokenKey)
+	if len(tokens) != 1 || subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(d.peers.Token)) != 1 {
+		return nil, status.Errorf(codes.Unauthenticated, "%s requires the peer token of the node plugins", info.FullMethod)
+	}
+	return handler(ctx, req)
+}
+
+// forwardExpansion sends the expansion request to the node plugin of the given node and returns its response
+func (d *Driver) forwardExpansion(ctx context.Context, node string, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	address, err := d.peers.Address(ctx, node)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to get address of the node plugin on node %s: %v", node, err)
+	}
+
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to connect to the node plugin on node %s at %s: %v", node, address, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	d.log.Info("forwarding volume expansion", "volume-id", req.GetVolumeId(), "node", node, "address", address)
+
+	ctx = metadata.AppendToOutgoingContext(ctx, peerTokenKey, d.peers.Token)
+	return csi.NewControllerClient(conn).ControllerExpandVolume(ctx, req)
+}
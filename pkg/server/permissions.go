@@ -0,0 +1,89 @@
+package server
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// rootDirectoryModeParameter configures the permissions of the root directory of filesystem volumes
+	rootDirectoryModeParameter = "rootDirectoryMode"
+	// legacyRootDirectoryMode makes every volume writable for everyone, as all volumes were before the mode could be configured
+	legacyRootDirectoryMode = "legacy"
+)
+
+// rootDirectoryMode returns the octal mode configured by the parameters, false is returned if it is not configured.
+func rootDirectoryMode(parameters map[string]string) (uint32, bool, error) {
+	value, ok := parameters[rootDirectoryModeParameter]
+	if !ok {
+		return 0, false, nil
+	}
+	if value == legacyRootDirectoryMode {
+		return 0o2777, true, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, false, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be an octal mode like 0750 or %s", rootDirectoryModeParameter, value, legacyRootDirectoryMode)
+	}
+
+	return uint32(mode), true, nil
+}
+
+// parseMountGroup returns the group id of a VolumeMountGroup, -1 is returned if it is empty
+func parseMountGroup(mountGroup string) (int, error) {
+	if mountGroup == "" {
+		return -1, nil
+	}
+
+	gid, err := strconv.Atoi(mountGroup)
+	if err != nil || gid < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid volume mount group %q: must be a numeric group id", mountGroup)
+	}
+
+	return gid, nil
+}
+
+// setRootPermissions applies the group and mode to the root directory of a mounted filesystem. Like kubelet does for
+// an fsGroup, the group gets read, write and setgid permissions if the mode is not configured explicitly.
+// Nothing is changed if neither group nor mode are given.
+func setRootPermissions(path string, gid int, parameters map[string]string) error {
+	mode, ok, err := rootDirectoryMode(parameters)
+	if err != nil {
+		return err
+	}
+	if gid < 0 && !ok {
+		return nil
+	}
+
+	var st unix.Stat_t
+	err = unix.Stat(path, &st)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to get permissions of %s: %v", path, err)
+	}
+
+	if gid >= 0 {
+		if st.Gid != uint32(gid) { //nolint:gosec
+			err = os.Lchown(path, -1, gid)
+			if err != nil {
+				return status.Errorf(codes.Internal, "unable to change group of %s to %d: %v", path, gid, err)
+			}
+		}
+		if !ok {
+			mode = st.Mode&0o7777 | 0o2770
+		}
+	}
+
+	if st.Mode&0o7777 != mode {
+		err = unix.Chmod(path, mode)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to change permissions of %s to %o: %v", path, mode, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	volumeIDVersion   = "v1"
+	volumeIDSeparator = ":"
+
+	// maxVolumeIDLength is the maximum length of volume and snapshot ids a CO has to support according to the CSI spec
+	maxVolumeIDLength = 128
+)
+
+// volumeID identifies a logical volume or snapshot of this driver.
+//
+// Ids created by this driver have the format v1:<vg>:<lv>:<node>, neither vg, lv nor node names
+// are allowed to contain a colon. Volumes which were created before this format was introduced
+// simply use the name of the logical volume as id, their volume group has to be looked up.
+type volumeID struct {
+	VGName string
+	LVName string
+	// Node is optional, if it is set the volume can only be used on this node
+	Node string
+}
+
+func (v volumeID) String() string {
+	parts := []string{volumeIDVersion, v.VGName, v.LVName}
+	if v.Node != "" {
+		parts = append(parts, v.Node)
+	}
+	return strings.Join(parts, volumeIDSeparator)
+}
+
+// parseVolumeID parses the given id, for plain ids of older volumes only the name of the logical volume is set.
+func parseVolumeID(id string) (volumeID, error) {
+	if !strings.HasPrefix(id, volumeIDVersion+volumeIDSeparator) {
+		return volumeID{LVName: id}, nil
+	}
+
+	parts := strings.Split(id, volumeIDSeparator)
+	if len(parts) < 3 || len(parts) > 4 || slices.Contains(parts[1:], "") {
+		return volumeID{}, fmt.Errorf("invalid volume id %q", id)
+	}
+
+	vid := volumeID{
+		VGName: parts[1],
+		LVName: parts[2],
+	}
+	if len(parts) == 4 {
+		vid.Node = parts[3]
+	}
+
+	return vid, nil
+}
+
+// newVolumeID returns the id for the logical volume name in the given volume group of this node
+func (d *Driver) newVolumeID(vg string, name string) string {
+	return volumeID{VGName: vg, LVName: name, Node: d.nodeId}.String()
+}
+
+// checkVolumeID returns an InvalidArgument error if the id of the logical volume name in the given volume group
+// of this node would exceed the maximum length of the CSI spec, the volume must not be created in this case.
+func (d *Driver) checkVolumeID(vg string, name string) error {
+	id := d.newVolumeID(vg, name)
+	if len(id) > maxVolumeIDLength {
+		return status.Errorf(codes.InvalidArgument, "id %s of %s would be %d bytes long, which exceeds the maximum of %d bytes, the names of the volume group %s and the node %s have to be shorter",
+			id, name, len(id), maxVolumeIDLength, vg, d.nodeId)
+	}
+	return nil
+}
+
+// lookupVolume resolves the volume group and logical volume of the given id. An error is returned
+// if the id is invalid or belongs to another node or a volume group which is not managed by this driver.
+// found is false if the logical volume does not exist.
+func (d *Driver) lookupVolume(ctx context.Context, id string) (vid volumeID, found bool, err error) {
+	vid, err = parseVolumeID(id)
+	if err != nil {
+		return vid, false, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if vid.VGName == "" {
+		vid.VGName, found, err = d.lookupVG(ctx, vid.LVName)
+		return vid, found, err
+	}
+
+	if err := d.verifyLocation(vid, id); err != nil {
+		return vid, false, err
+	}
+
+	lv, err := d.lvm.GetLV(ctx, vid.VGName, vid.LVName)
+	if err != nil {
+		return vid, false, statusError(err, "unable to lookup volume %s", id)
+	}
+
+	return vid, lv != nil, nil
+}
+
+// verifyLocation returns a NotFound error if the id belongs to another node or to a volume group which is not managed by this driver.
+func (d *Driver) verifyLocation(vid volumeID, id string) error {
+	if vid.Node != "" && vid.Node != d.nodeId {
+		return status.Errorf(codes.NotFound, "%s belongs to node %s", id, vid.Node)
+	}
+	if vid.VGName != "" && !slices.Contains(d.vgNames(), vid.VGName) {
+		return status.Errorf(codes.NotFound, "volume group %s of %s is not managed by this driver", vid.VGName, id)
+	}
+	return nil
+}
+
+// existingVolume is like lookupVolume but also returns a NotFound error if the logical volume does not exist.
+func (d *Driver) existingVolume(ctx context.Context, id string) (volumeID, error) {
+	vid, found, err := d.lookupVolume(ctx, id)
+	if err != nil {
+		return vid, err
+	}
+	if !found {
+		return vid, status.Errorf(codes.NotFound, "volume %s not found", id)
+	}
+	return vid, nil
+}